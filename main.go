@@ -4,11 +4,17 @@ Package main provides the encodeServer executable. See README.md for usage infor
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 
 	"github.com/ifIMust/encodeServer/server"
+	"github.com/ifIMust/encodeServer/server/handler"
+	"github.com/ifIMust/encodeServer/server/model"
 )
 
 const (
@@ -16,9 +22,43 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		runSnapshot(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		runRestore(os.Args[2:])
+		return
+	}
+
+	algorithm := flag.String("algorithm", handler.DefaultAlgorithm,
+		fmt.Sprintf("default hash algorithm %v", handler.Algorithms()))
+	iterations := flag.Int("iterations", handler.DefaultIterations, "iteration count for salted hash algorithms")
+	maxDigestSize := flag.Int64("max-digest-size", handler.DefaultMaxDigestSize, "maximum size in bytes of a payload sent to /digest")
+	idMode := flag.String("ids", handler.IdModeInteger, "kind of id issued for new hashes: integer or uuid")
+	ttl := flag.Duration("ttl", 0, "how long hashes are kept once stored, unless a request specifies otherwise; 0 keeps them indefinitely")
+	sweepInterval := flag.Duration("sweep-interval", handler.DefaultSweepInterval, "how often expired hashes are deleted")
+	keyringPath := flag.String("keyring", "", "pepper keyring file; reloaded on SIGHUP")
+	logDir := flag.String("log", "", "directory of the append-only log in which to persist hashes; by default hashes are kept in memory")
+	syncName := flag.String("sync", "always", "when to flush the log to disk: always, periodic or never")
+	syncInterval := flag.Duration("sync-interval", model.DefaultSyncInterval, "flush interval for -sync periodic")
+	segmentSize := flag.Int64("segment-size", model.DefaultMaxSegmentSize, "size in bytes at which a new log segment is started")
+	adminToken := flag.String("admin-token", os.Getenv("ENCODESERVER_ADMIN_TOKEN"),
+		"bearer token authorizing /admin/ requests; defaults to $ENCODESERVER_ADMIN_TOKEN")
+	webhookSecret := flag.String("webhook-secret", os.Getenv("ENCODESERVER_WEBHOOK_SECRET"),
+		"key for signing completion webhooks; defaults to $ENCODESERVER_WEBHOOK_SECRET")
+	webhookAllowPrivate := flag.Bool("webhook-allow-private", false,
+		"allow completion webhooks to loopback, private and link-local addresses")
+	maxHashes := flag.Int("max-hashes", 0, "maximum number of hashes to keep; 0 for no limit")
+	maxBytes := flag.Int64("max-bytes", 0, "maximum approximate size in bytes of the hashes to keep; 0 for no limit")
+	evictionName := flag.String("eviction", "lru", "which hash to evict when a limit is reached: lru or oldest")
+	rejectWhenFull := flag.Bool("reject-when-full", false, "refuse new hashes with status 507 when a limit is reached, rather than evicting")
+	compactInterval := flag.Duration("compact-interval", model.DefaultCompactInterval, "how often to consider compacting the log")
+	flag.Parse()
+
 	port := defaultPort
-	if len(os.Args) > 1 {
-		port = os.Args[1]
+	if flag.NArg() > 0 {
+		port = flag.Arg(0)
 		intPort, err := strconv.Atoi(port)
 		if err != nil {
 			fmt.Println("Invalid (non-numeric) port specified.")
@@ -30,7 +70,162 @@ func main() {
 		}
 
 	}
-	server := server.NewServer(port)
+	store, err := openStore(*logDir, *syncName, model.LogStoreOptions{
+		SyncInterval:    *syncInterval,
+		MaxSegmentSize:  *segmentSize,
+		CompactInterval: *compactInterval,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *maxHashes != 0 || *maxBytes != 0 {
+		store, err = boundStore(store, *evictionName, model.BoundedStoreOptions{
+			MaxRecords: *maxHashes,
+			MaxBytes:   *maxBytes,
+			Reject:     *rejectWhenFull,
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	server := server.NewServer(port, store)
+	server.SetAdminToken(*adminToken)
+	server.SetWebhookSecret(*webhookSecret)
+	server.SetWebhookAllowPrivate(*webhookAllowPrivate)
+	if err := server.SetDefaultAlgorithm(*algorithm); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := server.SetIterations(*iterations); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := server.SetIdMode(*idMode); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := server.SetTTL(*ttl); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := server.SetSweepInterval(*sweepInterval); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := server.SetMaxDigestSize(*maxDigestSize); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if *keyringPath != "" {
+		keyring, err := handler.LoadKeyring(*keyringPath)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		server.SetKeyring(keyring)
+		go reloadKeyringOnHangup(keyring, *keyringPath)
+	}
 	server.Run()
 	<-server.ShutdownComplete
 }
+
+// openStore opens the log store in logDir, or returns a memory store if logDir is empty.
+func openStore(logDir string, syncName string, options model.LogStoreOptions) (model.HashStore, error) {
+	if logDir == "" {
+		return model.NewMemoryStore(), nil
+	}
+	policy, err := model.ParseSyncPolicy(syncName)
+	if err != nil {
+		return nil, err
+	}
+	options.SyncPolicy = policy
+	return model.OpenLogStore(logDir, options)
+}
+
+// boundStore limits the hashes kept in store, evicting them according to the named policy.
+func boundStore(store model.HashStore, evictionName string, options model.BoundedStoreOptions) (model.HashStore, error) {
+	policy, err := model.ParseEvictionPolicy(evictionName)
+	if err != nil {
+		return nil, err
+	}
+	options.Policy = policy
+	return model.NewBoundedStore(store, options)
+}
+
+// runSnapshot implements the snapshot command, which writes a snapshot of a log that is not in use.
+// Opening the log fails if a running server holds its lock.
+func runSnapshot(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	logDir := flags.String("log", "", "directory of the log to snapshot")
+	output := flags.String("o", "", "file to write the snapshot to; defaults to standard output")
+	flags.Parse(args)
+	if *logDir == "" {
+		fmt.Println("A log directory must be specified with -log.")
+		return
+	}
+	store, err := model.OpenLogStore(*logDir, model.LogStoreOptions{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer store.Close()
+	w := os.Stdout
+	if *output != "" {
+		w, err = os.Create(*output)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer w.Close()
+	}
+	if err := model.SnapshotStore(w, store); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// runRestore implements the restore command, which loads a snapshot into an empty log.
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	logDir := flags.String("log", "", "directory of the log to restore into")
+	input := flags.String("i", "", "file to read the snapshot from; defaults to standard input")
+	flags.Parse(args)
+	if *logDir == "" {
+		fmt.Println("A log directory must be specified with -log.")
+		return
+	}
+	store, err := model.OpenLogStore(*logDir, model.LogStoreOptions{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer store.Close()
+	r := os.Stdin
+	if *input != "" {
+		r, err = os.Open(*input)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer r.Close()
+	}
+	header, err := model.RestoreStore(r, store)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Restored %d hashes; next id is %d.\n", header.Count, header.LastId+1)
+}
+
+// reloadKeyringOnHangup loads the keyring file again each time the process receives SIGHUP,
+// so that a new active key can be appended to the file without restarting the server.
+func reloadKeyringOnHangup(keyring *handler.Keyring, path string) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := keyring.Load(path); err != nil {
+			log.Println(err)
+		}
+	}
+}
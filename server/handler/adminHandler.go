@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+An AdminHandler handles requests to the '/admin/snapshot' endpoint, which exports and restores
+the records of a HashHandler.
+A GET request streams a point-in-time snapshot of every record in the JSON lines format written
+by model.WriteSnapshot. A POST request whose body is such a snapshot loads it into the
+HashHandler, which must not yet hold any hashes; ids and the next id are preserved.
+
+Every request must carry the admin token in an "Authorization: Bearer <token>" header.
+If no admin token has been set, all requests are refused.
+*/
+type AdminHandler struct {
+	hashHandler *HashHandler
+	token       string
+	run         atomic.Value
+}
+
+/*
+NewAdminHandler initializes and returns a new AdminHandler for the records of hashHandler.
+*/
+func NewAdminHandler(hashHandler *HashHandler) *AdminHandler {
+	a := new(AdminHandler)
+	a.hashHandler = hashHandler
+	a.run.Store(true)
+	return a
+}
+
+/*
+HandleRequest is an http request handler intended for use with http.ServeMux.
+*/
+func (a *AdminHandler) HandleRequest(w http.ResponseWriter, request *http.Request) {
+	if a.run.Load().(bool) {
+		var err error = nil
+		if !a.IsAuthorized(request) {
+			err = newRequestError(http.StatusForbidden, errors.New("unauthorized admin request"))
+		} else if request.Method == "GET" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			err = a.hashHandler.exportSnapshot(w)
+		} else if request.Method == "POST" {
+			err = a.hashHandler.restoreSnapshot(request.Body)
+		} else {
+			err = errors.New(fmt.Sprintf("unsupported request type: %s", request.Method))
+		}
+		if err != nil {
+			log.Println(err)
+			writeError(w, request, err)
+		}
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+/*
+Shutdown disables further handling of requests by this handler.
+*/
+func (a *AdminHandler) Shutdown() {
+	a.run.Store(false)
+}
+
+/*
+SetToken sets the token that authorizes admin requests. An empty token refuses all requests,
+which is the default.
+*/
+func (a *AdminHandler) SetToken(token string) {
+	a.token = token
+}
+
+/*
+IsAuthorized reports whether request carries the admin token.
+*/
+func (a *AdminHandler) IsAuthorized(request *http.Request) bool {
+	if a.token == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
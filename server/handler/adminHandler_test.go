@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+func newAdminRequest(t *testing.T, method string, token string, body []byte) *http.Request {
+	req, err := http.NewRequest(method, "http://12.34.56.78:4321/admin/snapshot", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to construct %s request", method)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	a := NewAdminHandler(NewHashHandler(model.NewStats(), model.NewMemoryStore(), new(sync.WaitGroup)))
+	writer := new(MockResponseWriter)
+	a.HandleRequest(writer, newAdminRequest(t, "GET", "secret", nil))
+	if writer.LastStatus != http.StatusForbidden {
+		t.Errorf("Expected status %d without a configured token, got %d", http.StatusForbidden, writer.LastStatus)
+	}
+	a.SetToken("secret")
+	writer = new(MockResponseWriter)
+	a.HandleRequest(writer, newAdminRequest(t, "GET", "guess", nil))
+	if writer.LastStatus != http.StatusForbidden {
+		t.Errorf("Expected status %d with a wrong token, got %d", http.StatusForbidden, writer.LastStatus)
+	}
+}
+
+func TestAdminSnapshotRestore(t *testing.T) {
+	source := NewHashHandler(model.NewStats(), model.NewMemoryStore(), new(sync.WaitGroup))
+	source.SetDelay(0)
+	source.submitHash("first", "sha256", 0, "")
+	source.submitHash("second", "", 0, "")
+	source.waitGroup.Wait()
+	source.getNextHashId("", 0, "")
+	sourceAdmin := NewAdminHandler(source)
+	sourceAdmin.SetToken("secret")
+	snapshot := new(bytes.Buffer)
+	source.exportSnapshot(snapshot)
+
+	target := NewHashHandler(model.NewStats(), model.NewMemoryStore(), new(sync.WaitGroup))
+	targetAdmin := NewAdminHandler(target)
+	targetAdmin.SetToken("secret")
+	writer := new(MockResponseWriter)
+	targetAdmin.HandleRequest(writer, newAdminRequest(t, "POST", "secret", snapshot.Bytes()))
+	if writer.LastStatus != 0 {
+		t.Fatalf("Restore failed with status %d", writer.LastStatus)
+	}
+	if !bytes.Equal(target.getHash(1), source.getHash(1)) || target.getRecord(2).Algorithm != DefaultAlgorithm {
+		t.Errorf("Records were not restored")
+	}
+	if id := target.getNextHashId("", 0, ""); id != 4 {
+		t.Errorf("Expected next id %d, got %d", 4, id)
+	}
+
+	writer = new(MockResponseWriter)
+	targetAdmin.HandleRequest(writer, newAdminRequest(t, "POST", "secret", snapshot.Bytes()))
+	if writer.LastStatus != http.StatusConflict {
+		t.Errorf("Expected status %d restoring into a server with hashes, got %d", http.StatusConflict, writer.LastStatus)
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+const (
+	// DefaultMaxBatchItems is the default limit on the number of passwords in one batch request.
+	DefaultMaxBatchItems = 100000
+	// MaxBatchItemBytes is the room allowed in a batch request body for each item, up to the item limit.
+	MaxBatchItemBytes = 1024
+)
+
+/*
+A BatchHandler handles POST requests to the '/batch' endpoint, which submit many passwords
+for hashing at once. The request body is a JSON array of BatchItem objects.
+
+A request with more items than the limit, or a body longer than MaxBatchItemBytes per allowed item,
+is refused with 413 Request Entity Too Large before the rest of it is read.
+
+The response is a JSON array of BatchResult objects, in the same order as the request.
+Each item is validated and submitted independently, exactly as a POST request to '/hash' would be:
+a valid item is assigned an id, and an invalid item reports an error without affecting the others.
+*/
+type BatchHandler struct {
+	hashHandler *HashHandler
+	stats       *model.Stats
+	maxItems    int
+	run         atomic.Value
+}
+
+/*
+A BatchItem is used for unmarshaling one password of a batch request from JSON.
+*/
+type BatchItem struct {
+	Password  string `json:"password"`
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+/*
+A BatchResult is used for marshaling the outcome of one item of a batch request to JSON.
+*/
+type BatchResult struct {
+	Id    HashId `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+/*
+NewBatchHandler initializes and returns a new BatchHandler.
+Hashes are submitted to hashHandler, and request statistics are logged to stats.
+*/
+func NewBatchHandler(hashHandler *HashHandler, stats *model.Stats) *BatchHandler {
+	b := new(BatchHandler)
+	b.hashHandler = hashHandler
+	b.stats = stats
+	b.maxItems = DefaultMaxBatchItems
+	b.run.Store(true)
+	return b
+}
+
+/*
+HandleRequest is an http request handler intended for use with http.ServeMux.
+*/
+func (b *BatchHandler) HandleRequest(w http.ResponseWriter, request *http.Request) {
+	if b.run.Load().(bool) {
+		var err error = nil
+		if request.Method == "POST" {
+			err = b.handlePost(w, request)
+		} else {
+			err = errors.New(fmt.Sprintf("unsupported request type: %s", request.Method))
+		}
+		if err != nil {
+			log.Println(err)
+			writeError(w, request, err)
+		}
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+/*
+Shutdown disables further handling of requests by this handler.
+*/
+func (b *BatchHandler) Shutdown() {
+	b.run.Store(false)
+}
+
+/*
+SetMaxItems modifies the limit on the number of passwords in one request. The default is DefaultMaxBatchItems.
+*/
+func (b *BatchHandler) SetMaxItems(maxItems int) error {
+	if maxItems < 1 {
+		return errors.New(fmt.Sprintf("invalid maximum batch size: %d", maxItems))
+	}
+	b.maxItems = maxItems
+	return nil
+}
+
+func (b *BatchHandler) handlePost(w http.ResponseWriter, request *http.Request) error {
+	startTime := time.Now()
+	request.Body = http.MaxBytesReader(w, request.Body, int64(b.maxItems)*MaxBatchItemBytes)
+	items, err := b.readItems(request)
+	if err != nil {
+		return err
+	}
+	results := make([]BatchResult, len(items))
+	for i, item := range items {
+		id, err := b.hashHandler.submitHash(item.Password, item.Algorithm, 0, "")
+		if err != nil {
+			results[i].Error = err.Error()
+		} else {
+			results[i].Id = b.hashHandler.externalId(id)
+		}
+	}
+	output, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+	processingTime := time.Now().Sub(startTime)
+	b.stats.AddRequest(processingTime)
+	return nil
+}
+
+/*
+readItems decodes the JSON array of BatchItems in the request body one item at a time,
+and stops as soon as the array holds more items than the limit.
+*/
+func (b *BatchHandler) readItems(request *http.Request) ([]BatchItem, error) {
+	decoder := json.NewDecoder(request.Body)
+	malformed := func(err error) error {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return newRequestError(http.StatusRequestEntityTooLarge, err)
+		}
+		return newRequestError(http.StatusBadRequest, errors.New(fmt.Sprintf("malformed batch request: %s", err)))
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, malformed(err)
+	}
+	if token != json.Delim('[') {
+		return nil, malformed(errors.New("expected an array"))
+	}
+	items := []BatchItem{}
+	for decoder.More() {
+		if len(items) == b.maxItems {
+			return nil, newRequestError(http.StatusRequestEntityTooLarge,
+				errors.New(fmt.Sprintf("batch exceeds limit of %d items", b.maxItems)))
+		}
+		var item BatchItem
+		if err := decoder.Decode(&item); err != nil {
+			return nil, malformed(err)
+		}
+		items = append(items, item)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, malformed(err)
+	}
+	return items, nil
+}
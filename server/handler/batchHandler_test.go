@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+func postBatch(t *testing.T, b *BatchHandler, body string) *MockResponseWriter {
+	req, err := http.NewRequest("POST", "http://12.34.56.78:4321/batch", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to construct POST request")
+	}
+	writer := new(MockResponseWriter)
+	b.HandleRequest(writer, req)
+	return writer
+}
+
+func TestBatchAssignsIdsInOrder(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetDelay(0)
+	b := NewBatchHandler(h, stats)
+	writer := postBatch(t, b, `[{"password":"a"},{"password":""},{"password":"c","algorithm":"rot13"},{"password":"d","algorithm":"sha256"}]`)
+	expected := `[{"id":1},{"error":"malformed request: empty 'password' field"},{"error":"unsupported hash algorithm: rot13"},{"id":2}]`
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+	h.waitGroup.Wait()
+	if h.getRecord(2).Algorithm != "sha256" {
+		t.Errorf("Expected sha256 record, got %v", h.getRecord(2))
+	}
+}
+
+func TestBatchMalformed(t *testing.T) {
+	stats := model.NewStats()
+	b := NewBatchHandler(NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup)), stats)
+	writer := postBatch(t, b, `{"password":"a"}`)
+	if writer.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, writer.LastStatus)
+	}
+}
+
+func TestBatchTooLarge(t *testing.T) {
+	stats := model.NewStats()
+	b := NewBatchHandler(NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup)), stats)
+	b.SetMaxItems(1)
+	writer := postBatch(t, b, `[{"password":"a"},{"password":"b"}]`)
+	if writer.LastStatus != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, writer.LastStatus)
+	}
+}
+
+func TestBatchBodyTooLarge(t *testing.T) {
+	stats := model.NewStats()
+	b := NewBatchHandler(NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup)), stats)
+	b.SetMaxItems(2)
+	writer := postBatch(t, b, `[{"password":"`+strings.Repeat("a", 2*MaxBatchItemBytes)+`"}]`)
+	if writer.LastStatus != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, writer.LastStatus)
+	}
+}
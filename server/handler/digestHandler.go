@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+const (
+	// DefaultMaxDigestSize is the default limit, in bytes, on the size of a payload sent to '/digest'.
+	DefaultMaxDigestSize = 32 << 20
+)
+
+/*
+A DigestHandler handles POST requests to the '/digest' endpoint, which fingerprint arbitrary
+binary payloads. The payload is either the raw request body, or the part named "file" of a
+multipart/form-data request body. It is hashed as it is read, without being buffered in memory.
+
+An optional "algorithm" query parameter selects the hash algorithm, which must support streaming.
+Otherwise the HashHandler's default algorithm is used if it supports streaming, and DefaultAlgorithm
+if it does not.
+
+As with a POST request to '/hash', the response is the id of the hash, which may be retrieved
+from '/hash/N' once the HashHandler's delay has passed.
+*/
+type DigestHandler struct {
+	hashHandler *HashHandler
+	stats       *model.Stats
+	maxSize     int64
+	run         atomic.Value
+}
+
+/*
+NewDigestHandler initializes and returns a new DigestHandler.
+Hashes are stored by hashHandler, and request statistics are logged to stats.
+*/
+func NewDigestHandler(hashHandler *HashHandler, stats *model.Stats) *DigestHandler {
+	d := new(DigestHandler)
+	d.hashHandler = hashHandler
+	d.stats = stats
+	d.maxSize = DefaultMaxDigestSize
+	d.run.Store(true)
+	return d
+}
+
+/*
+HandleRequest is an http request handler intended for use with http.ServeMux.
+*/
+func (d *DigestHandler) HandleRequest(w http.ResponseWriter, request *http.Request) {
+	if d.run.Load().(bool) {
+		var err error = nil
+		if request.Method == "POST" {
+			err = d.handlePost(w, request)
+		} else {
+			err = errors.New(fmt.Sprintf("unsupported request type: %s", request.Method))
+		}
+		if err != nil {
+			log.Println(err)
+			writeError(w, request, err)
+		}
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+/*
+Shutdown disables further handling of requests by this handler.
+*/
+func (d *DigestHandler) Shutdown() {
+	d.run.Store(false)
+}
+
+/*
+SetMaxSize modifies the limit, in bytes, on the size of a payload. The default is DefaultMaxDigestSize.
+*/
+func (d *DigestHandler) SetMaxSize(maxSize int64) error {
+	if maxSize < 1 {
+		return errors.New(fmt.Sprintf("invalid maximum digest size: %d", maxSize))
+	}
+	d.maxSize = maxSize
+	return nil
+}
+
+func (d *DigestHandler) handlePost(w http.ResponseWriter, request *http.Request) error {
+	startTime := time.Now()
+	hasher, err := d.getHasher(request.URL.Query().Get("algorithm"))
+	if err != nil {
+		return err
+	}
+	// Refuse early rather than read a payload that cannot be stored. Room is checked again
+	// when the record is submitted, since other hashes may be admitted while this one is read.
+	if err := d.hashHandler.checkRoom(hasher.Algorithm()); err != nil {
+		return err
+	}
+	request.Body = http.MaxBytesReader(w, request.Body, d.maxSize)
+	payload, err := d.getPayload(request)
+	if err != nil {
+		return err
+	}
+	hash, err := hasher.HashStream(payload)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return newRequestError(http.StatusRequestEntityTooLarge, err)
+		}
+		return err
+	}
+	id, err := d.hashHandler.submitRecord(model.HashRecord{Algorithm: hasher.Algorithm(), Hash: hash})
+	if err != nil {
+		return err
+	}
+	io.WriteString(w, string(d.hashHandler.externalId(id)))
+	processingTime := time.Now().Sub(startTime)
+	d.stats.AddRequest(processingTime)
+	return nil
+}
+
+func (d *DigestHandler) getHasher(algorithm string) (StreamHasher, error) {
+	if algorithm == "" {
+		if hasher, ok := d.hashHandler.hasher.(StreamHasher); ok {
+			return hasher, nil
+		}
+		algorithm = DefaultAlgorithm
+	}
+	hasher, err := GetHasher(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	streamHasher, ok := hasher.(StreamHasher)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("hash algorithm does not support streaming: %s", algorithm))
+	}
+	return streamHasher, nil
+}
+
+/*
+getPayload returns a reader for the "file" part of a multipart request, or for the body of
+any other request.
+*/
+func (d *DigestHandler) getPayload(request *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return request.Body, nil
+	}
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("malformed request: no 'file' part: %s", err))
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+func newDigestHandler() (*DigestHandler, *HashHandler) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetDelay(0)
+	return NewDigestHandler(h, stats), h
+}
+
+func postDigest(t *testing.T, d *DigestHandler, url string, contentType string, body []byte) *MockResponseWriter {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to construct POST request")
+	}
+	req.Header.Set("Content-Type", contentType)
+	writer := new(MockResponseWriter)
+	d.HandleRequest(writer, req)
+	return writer
+}
+
+func TestDigestRawBody(t *testing.T) {
+	d, h := newDigestHandler()
+	payload := []byte{0, 1, 2, 3, 0xff}
+	writer := postDigest(t, d, "http://12.34.56.78:4321/digest?algorithm=sha256", "application/octet-stream", payload)
+	id, err := strconv.Atoi(string(writer.LastData))
+	if err != nil {
+		t.Fatalf("Expected id, got %s", writer.LastData)
+	}
+	h.waitGroup.Wait()
+	expected := sha256.Sum256(payload)
+	record := h.getRecord(id)
+	if record.Algorithm != "sha256" || !bytes.Equal(record.Hash, expected[:]) {
+		t.Errorf("Expected sha256 %x, got %s %x", expected, record.Algorithm, record.Hash)
+	}
+}
+
+func TestDigestMultipartFile(t *testing.T) {
+	d, h := newDigestHandler()
+	payload := []byte("artifact contents")
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	form.WriteField("comment", "ignored")
+	file, _ := form.CreateFormFile("file", "artifact.bin")
+	file.Write(payload)
+	form.Close()
+	writer := postDigest(t, d, "http://12.34.56.78:4321/digest?algorithm=sha256", form.FormDataContentType(), body.Bytes())
+	id, err := strconv.Atoi(string(writer.LastData))
+	if err != nil {
+		t.Fatalf("Expected id, got %s", writer.LastData)
+	}
+	h.waitGroup.Wait()
+	expected := sha256.Sum256(payload)
+	if !bytes.Equal(h.getHash(id), expected[:]) {
+		t.Errorf("Expected %x, got %x", expected, h.getHash(id))
+	}
+}
+
+func TestDigestTooLarge(t *testing.T) {
+	d, _ := newDigestHandler()
+	d.SetMaxSize(4)
+	writer := postDigest(t, d, "http://12.34.56.78:4321/digest", "application/octet-stream", []byte("12345"))
+	if writer.LastStatus != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, writer.LastStatus)
+	}
+}
+
+func TestDigestSaltedAlgorithm(t *testing.T) {
+	d, _ := newDigestHandler()
+	writer := postDigest(t, d, "http://12.34.56.78:4321/digest?algorithm=pbkdf2-sha512", "application/octet-stream", []byte("12345"))
+	if writer.LastStatus != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, writer.LastStatus)
+	}
+}
+
+func TestDigestConcurrentUploadsWhenFull(t *testing.T) {
+	stats := model.NewStats()
+	store, _ := model.NewBoundedStore(model.NewMemoryStore(), model.BoundedStoreOptions{MaxRecords: 1, Reject: true})
+	h := NewHashHandler(stats, store, new(sync.WaitGroup))
+	h.SetDelay(time.Hour)
+	d := NewDigestHandler(h, stats)
+
+	// Every upload passes the early check while its body is still being read.
+	const uploads = 5
+	var pipes [uploads]*io.PipeWriter
+	var writers [uploads]*MockResponseWriter
+	var requests sync.WaitGroup
+	for i := range pipes {
+		var body *io.PipeReader
+		body, pipes[i] = io.Pipe()
+		req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/digest?algorithm=sha256", body)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		writers[i] = new(MockResponseWriter)
+		requests.Add(1)
+		go func(writer *MockResponseWriter) {
+			d.HandleRequest(writer, req)
+			requests.Done()
+		}(writers[i])
+	}
+	for _, pipe := range pipes {
+		pipe.Write([]byte("payload"))
+	}
+	for _, pipe := range pipes {
+		pipe.Close()
+	}
+	requests.Wait()
+
+	accepted := 0
+	for _, writer := range writers {
+		if writer.LastStatus == 0 {
+			accepted++
+		} else if writer.LastStatus != http.StatusInsufficientStorage {
+			t.Errorf("Expected status %d, got %d", http.StatusInsufficientStorage, writer.LastStatus)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("Expected 1 upload to be accepted, had %d", accepted)
+	}
+}
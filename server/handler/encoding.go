@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultEncoding is the encoding of hash responses that do not request one.
+	DefaultEncoding = "base64"
+	// EncodingMediaTypePrefix prefixes an encoding name to form an acceptable media type for that encoding.
+	EncodingMediaTypePrefix = "application/x-hash-"
+)
+
+var encodings = map[string]func([]byte) string{
+	"base64":    base64.StdEncoding.EncodeToString,
+	"base64raw": base64.RawStdEncoding.EncodeToString,
+	"base64url": base64.RawURLEncoding.EncodeToString,
+	"base32":    base32.StdEncoding.EncodeToString,
+	"hex":       hex.EncodeToString,
+}
+
+/*
+Encode returns hash as text in the named encoding.
+An unsupported encoding is reported as a bad request.
+*/
+func Encode(encoding string, hash []byte) (string, error) {
+	encode, ok := encodings[encoding]
+	if !ok {
+		return "", newRequestError(http.StatusBadRequest, errors.New(fmt.Sprintf("unsupported encoding: %s", encoding)))
+	}
+	return encode(hash), nil
+}
+
+/*
+Encodings returns the sorted names of all supported encodings.
+*/
+func Encodings() []string {
+	names := make([]string, 0, len(encodings))
+	for name := range encodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+requestedEncoding returns the encoding named by the request's "encoding" query parameter or,
+failing that, by the first media type of the form application/x-hash-<encoding> that the
+request accepts. Otherwise it returns DefaultEncoding.
+*/
+func requestedEncoding(request *http.Request) string {
+	if encoding := request.URL.Query().Get("encoding"); encoding != "" {
+		return encoding
+	}
+	for _, accept := range strings.Split(request.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(accept), ";")
+		if encoding, ok := strings.CutPrefix(mediaType, EncodingMediaTypePrefix); ok {
+			return encoding
+		}
+	}
+	return DefaultEncoding
+}
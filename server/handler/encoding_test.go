@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestEncodeAll(t *testing.T) {
+	hash := []byte{0xfb, 0xff, 0x00, 0x10}
+	expected := map[string]string{
+		"base64":    "+/8AEA==",
+		"base64raw": "+/8AEA",
+		"base64url": "-_8AEA",
+		"base32":    "7P7QAEA=",
+		"hex":       "fbff0010",
+	}
+	for _, encoding := range Encodings() {
+		result, err := Encode(encoding, hash)
+		if err != nil {
+			t.Errorf("Failed to encode %s: %s", encoding, err)
+		}
+		if result != expected[encoding] {
+			t.Errorf("Expected %s encoding %s, got %s", encoding, expected[encoding], result)
+		}
+	}
+}
+
+func TestEncodeUnknown(t *testing.T) {
+	_, err := Encode("rot13", []byte{1})
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) || reqErr.status != http.StatusBadRequest {
+		t.Errorf("Expected bad request error for unknown encoding, got %v", err)
+	}
+}
+
+func TestRequestedEncoding(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://12.34.56.78:4321/hash/1", nil)
+	if requestedEncoding(req) != DefaultEncoding {
+		t.Errorf("Expected default encoding, got %s", requestedEncoding(req))
+	}
+	req.Header.Set("Accept", "text/plain, application/x-hash-base32;q=0.9")
+	if requestedEncoding(req) != "base32" {
+		t.Errorf("Expected base32 encoding, got %s", requestedEncoding(req))
+	}
+	req, _ = http.NewRequest("GET", "http://12.34.56.78:4321/hash/1?encoding=hex", nil)
+	req.Header.Set("Accept", "application/x-hash-base32")
+	if requestedEncoding(req) != "hex" {
+		t.Errorf("Expected hex encoding, got %s", requestedEncoding(req))
+	}
+}
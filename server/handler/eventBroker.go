@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEventBufferSize is the number of recent events kept for clients resuming a stream.
+	DefaultEventBufferSize = 1000
+	// subscriberBufferSize is the number of events that may be queued for one subscriber
+	// before it is dropped for falling behind.
+	subscriberBufferSize = 256
+
+	EventCreated   = "created"
+	EventCompleted = "completed"
+	EventDeleted   = "deleted"
+	EventExpired   = "expired"
+)
+
+/*
+A HashEvent records a change to a hash. Seq numbers events in the order they happened, starting at 1.
+*/
+type HashEvent struct {
+	Seq    uint64    `json:"-"`
+	Type   string    `json:"type"`
+	HashId HashId    `json:"hash_id"`
+	Time   time.Time `json:"time"`
+}
+
+/*
+An eventBroker delivers HashEvents to subscribers as they are published, and keeps the most
+recent events in a ring buffer so that a subscriber can resume after the last event it saw.
+A subscriber that falls too far behind is dropped, by closing its channel; it may then
+subscribe again from the last event it received.
+*/
+type eventBroker struct {
+	buffer      []HashEvent
+	lastSeq     uint64
+	subscribers map[chan HashEvent]bool
+	mutex       sync.Mutex
+}
+
+func newEventBroker(bufferSize int) *eventBroker {
+	b := new(eventBroker)
+	b.buffer = make([]HashEvent, bufferSize)
+	b.subscribers = make(map[chan HashEvent]bool)
+	return b
+}
+
+/*
+publish records an event of the given type for hashId, and sends it to every subscriber.
+*/
+func (b *eventBroker) publish(eventType string, hashId HashId) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.lastSeq++
+	event := HashEvent{b.lastSeq, eventType, hashId, time.Now()}
+	b.buffer[b.lastSeq%uint64(len(b.buffer))] = event
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+/*
+subscribe returns the buffered events published after the event numbered afterSeq, and a channel
+that receives each later event. The channel is closed if the subscriber is dropped.
+The returned function unsubscribes.
+*/
+func (b *eventBroker) subscribe(afterSeq uint64) ([]HashEvent, <-chan HashEvent, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var backlog []HashEvent
+	oldest := uint64(1)
+	if b.lastSeq >= uint64(len(b.buffer)) {
+		oldest = b.lastSeq - uint64(len(b.buffer)) + 1
+	}
+	if afterSeq+1 > oldest {
+		oldest = afterSeq + 1
+	}
+	for seq := oldest; seq <= b.lastSeq; seq++ {
+		backlog = append(backlog, b.buffer[seq%uint64(len(b.buffer))])
+	}
+	ch := make(chan HashEvent, subscriberBufferSize)
+	b.subscribers[ch] = true
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, unsubscribe
+}
@@ -0,0 +1,69 @@
+package handler
+
+import "testing"
+
+func TestEventBrokerBacklog(t *testing.T) {
+	b := newEventBroker(4)
+	b.publish(EventCreated, "1")
+	b.publish(EventCompleted, "1")
+	b.publish(EventCreated, "2")
+
+	backlog, _, unsubscribe := b.subscribe(0)
+	unsubscribe()
+	if len(backlog) != 3 || backlog[0].Seq != 1 || backlog[2].HashId != "2" {
+		t.Fatalf("Expected all three events, got %v", backlog)
+	}
+	backlog, _, unsubscribe = b.subscribe(2)
+	unsubscribe()
+	if len(backlog) != 1 || backlog[0].Seq != 3 || backlog[0].Type != EventCreated {
+		t.Errorf("Expected to resume after event 2, got %v", backlog)
+	}
+	backlog, _, unsubscribe = b.subscribe(3)
+	unsubscribe()
+	if len(backlog) != 0 {
+		t.Errorf("Expected no missed events, got %v", backlog)
+	}
+}
+
+func TestEventBrokerOverflow(t *testing.T) {
+	b := newEventBroker(4)
+	for i := 0; i < 10; i++ {
+		b.publish(EventCreated, "1")
+	}
+	backlog, _, unsubscribe := b.subscribe(2)
+	unsubscribe()
+	if len(backlog) != 4 || backlog[0].Seq != 7 || backlog[3].Seq != 10 {
+		t.Errorf("Expected only the 4 most recent events, got %v", backlog)
+	}
+}
+
+func TestEventBrokerLiveEvents(t *testing.T) {
+	b := newEventBroker(4)
+	_, events, unsubscribe := b.subscribe(0)
+	b.publish(EventDeleted, "5")
+	event := <-events
+	if event.Seq != 1 || event.Type != EventDeleted || event.HashId != "5" {
+		t.Errorf("Unexpected event %v", event)
+	}
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Errorf("Expected channel to be closed by unsubscribe")
+	}
+	unsubscribe()
+}
+
+func TestEventBrokerDropsSlowSubscriber(t *testing.T) {
+	b := newEventBroker(4)
+	_, events, unsubscribe := b.subscribe(0)
+	defer unsubscribe()
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		b.publish(EventCreated, "1")
+	}
+	received := 0
+	for range events {
+		received++
+	}
+	if received != subscriberBufferSize {
+		t.Errorf("Expected %d events before the subscriber was dropped, got %d", subscriberBufferSize, received)
+	}
+}
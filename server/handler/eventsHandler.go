@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKeepAlive is how often an idle event stream is sent a comment, so that proxies keep it open.
+const EventKeepAlive = 15 * time.Second
+
+/*
+An EventsHandler responds to GET requests to the '/events' endpoint with a stream of server-sent
+events, one for each hash created, completed, deleted or expired by its HashHandler.
+Each event carries the hash id, never the password or the hash. A client that reconnects with
+a Last-Event-ID header is first sent the buffered events it missed, if they are still buffered.
+Events for hashes with public ids are only streamed to requests with admin authorization
+(see HashHandler.SetAdminAuthorizer), for the same reason they are left out of listings.
+*/
+type EventsHandler struct {
+	hashHandler *HashHandler
+	run         atomic.Value
+	stopped     chan struct{}
+	stopOnce    sync.Once
+}
+
+/*
+NewEventsHandler initializes and returns a new EventsHandler streaming the events of hashHandler.
+*/
+func NewEventsHandler(hashHandler *HashHandler) *EventsHandler {
+	e := new(EventsHandler)
+	e.hashHandler = hashHandler
+	e.stopped = make(chan struct{})
+	e.run.Store(true)
+	return e
+}
+
+/*
+HandleRequest is an http request handler intended for use with http.ServeMux.
+*/
+func (e *EventsHandler) HandleRequest(w http.ResponseWriter, request *http.Request) {
+	if e.run.Load().(bool) {
+		var err error = nil
+		if request.Method == "GET" {
+			err = e.handleGet(w, request)
+		} else {
+			err = errors.New(fmt.Sprintf("unsupported request type: %s", request.Method))
+		}
+		if err != nil {
+			log.Println(err)
+			writeError(w, request, err)
+		}
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+/*
+Shutdown disables further handling of requests by this handler, and ends any open event streams.
+*/
+func (e *EventsHandler) Shutdown() {
+	e.run.Store(false)
+	e.stopOnce.Do(func() {
+		close(e.stopped)
+	})
+}
+
+/*
+handleGet streams events until the client disconnects, falls too far behind, or the handler shuts down.
+*/
+func (e *EventsHandler) handleGet(w http.ResponseWriter, request *http.Request) error {
+	var lastSeq uint64
+	if lastEventId := request.Header.Get("Last-Event-ID"); lastEventId != "" {
+		var err error
+		lastSeq, err = strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			return newRequestError(http.StatusBadRequest,
+				errors.New(fmt.Sprintf("malformed request: invalid Last-Event-ID: %s", lastEventId)))
+		}
+	}
+	isAdmin := e.hashHandler.isAdmin != nil && e.hashHandler.isAdmin(request)
+	visible := func(event HashEvent) bool {
+		return isAdmin || !event.HashId.isPublic()
+	}
+	flusher, _ := w.(http.Flusher)
+	backlog, events, unsubscribe := e.hashHandler.events.subscribe(lastSeq)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range backlog {
+		if !visible(event) {
+			continue
+		}
+		if err := writeEvent(w, event); err != nil {
+			return nil
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(EventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if !visible(event) {
+				continue
+			}
+			err = writeEvent(w, event)
+		case <-keepAlive.C:
+			_, err = w.Write([]byte(": keepalive\n\n"))
+		case <-request.Context().Done():
+			return nil
+		case <-e.stopped:
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+/*
+writeEvent writes event to w in the server-sent events format, with its Seq as the event id.
+*/
+func writeEvent(w http.ResponseWriter, event HashEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)))
+	return err
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+/*
+A streamRecorder is a ResponseWriter that keeps everything written to it, for testing streaming responses.
+*/
+type streamRecorder struct {
+	MockResponseWriter
+	mutex sync.Mutex
+	body  bytes.Buffer
+}
+
+func (s *streamRecorder) Write(data []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.body.Write(data)
+}
+
+func (s *streamRecorder) Flush() {}
+
+func (s *streamRecorder) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.body.String()
+}
+
+func (s *streamRecorder) waitFor(t *testing.T, text string) {
+	deadline := time.Now().Add(time.Second)
+	for !strings.Contains(s.String(), text) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected stream to contain %q, got %q", text, s.String())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetDelay(time.Millisecond)
+	e := NewEventsHandler(h)
+	request, _ := http.NewRequest("GET", "/events", nil)
+	w := new(streamRecorder)
+	done := make(chan bool)
+	go func() {
+		e.HandleRequest(w, request)
+		done <- true
+	}()
+
+	id, err := h.submitHash("angryMonkey", "", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.waitFor(t, "event: completed")
+	if w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Unexpected Content-Type %s", w.Header().Get("Content-Type"))
+	}
+	h.deleteHash(id)
+	w.waitFor(t, "event: deleted")
+	expected := "id: 1\nevent: created\ndata: {\"type\":\"created\",\"hash_id\":1,"
+	if !strings.HasPrefix(w.String(), expected) {
+		t.Errorf("Expected stream to start with %q, got %q", expected, w.String())
+	}
+	if strings.Contains(w.String(), "angryMonkey") {
+		t.Errorf("Password leaked into event stream")
+	}
+
+	e.Shutdown()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Event stream did not end on shutdown")
+	}
+}
+
+func TestEventStreamResume(t *testing.T) {
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetDelay(time.Hour)
+	h.submitHash("a", "", 0, "")
+	h.submitHash("b", "", 0, "")
+	h.submitHash("c", "", 0, "")
+	e := NewEventsHandler(h)
+
+	// A cancelled request ends the stream once the missed events are written.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request, _ := http.NewRequestWithContext(ctx, "GET", "/events", nil)
+	request.Header.Set("Last-Event-ID", "2")
+	w := new(streamRecorder)
+	e.HandleRequest(w, request)
+	if strings.Contains(w.String(), "id: 2\n") || !strings.Contains(w.String(), "id: 3\n") {
+		t.Errorf("Expected only event 3 after resuming, got %q", w.String())
+	}
+
+	request.Header.Set("Last-Event-ID", "three")
+	w = new(streamRecorder)
+	e.HandleRequest(w, request)
+	if w.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d for invalid Last-Event-ID, got %d", http.StatusBadRequest, w.LastStatus)
+	}
+}
+
+func TestEventStreamHidesPublicIds(t *testing.T) {
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetDelay(time.Hour)
+	admin := NewAdminHandler(h)
+	admin.SetToken("secret")
+	h.SetAdminAuthorizer(admin.IsAuthorized)
+	h.submitHash("a", "", 0, "")
+	h.SetIdMode(IdModeUUID)
+	id, _ := h.submitHash("b", "", 0, "")
+	publicId := h.publicIdOf(id)
+	e := NewEventsHandler(h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request, _ := http.NewRequestWithContext(ctx, "GET", "/events", nil)
+	w := new(streamRecorder)
+	e.HandleRequest(w, request)
+	if strings.Contains(w.String(), publicId) || !strings.Contains(w.String(), "id: 1\n") {
+		t.Errorf("Expected anonymous stream to hold only the integer id, got %q", w.String())
+	}
+
+	request.Header.Set("Authorization", "Bearer secret")
+	w = new(streamRecorder)
+	e.HandleRequest(w, request)
+	if !strings.Contains(w.String(), publicId) {
+		t.Errorf("Expected admin stream to include public id %s, got %q", publicId, w.String())
+	}
+}
@@ -4,10 +4,15 @@ Package handler provides request handlers for http requests.
 package handler
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -18,39 +23,174 @@ import (
 	"github.com/ifIMust/encodeServer/server/model"
 )
 
+const (
+	// PHCContentType is the media type of responses formatted as PHC strings.
+	PHCContentType = "application/x-phc"
+	// DefaultMaxBulkIds is the default limit on the number of ids in one bulk lookup.
+	DefaultMaxBulkIds = 1000
+	// MaxWait is the longest a GET request may wait for a pending hash to be stored.
+	MaxWait = time.Minute
+	// DefaultSweepInterval is the default interval between scans for expired hashes.
+	DefaultSweepInterval = time.Minute
+	// ExpiredRetention is how long the id of an expired hash is remembered after it is swept.
+	ExpiredRetention = 24 * time.Hour
+
+	StatusMissing   = "missing"
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusExpired   = "expired"
+)
+
 /*
 A HashHandler handles requests to the '/hash' endpoint.
 A POST request with a non-empty "password" field will be handled by computing the hash 5 seconds later.
-The response to this request is the id of the stored hash.
+An optional "algorithm" field selects the hash algorithm; otherwise the handler's default is used.
+An optional "ttl" field, such as "1h30m", sets how long the hash is kept once it is stored;
+otherwise the handler's default time-to-live is used, if any.
+An optional "callback_url" field names an http or https URL to which a signed WebhookPayload is
+posted once the hash is stored, retrying with exponential backoff if it fails. Callback URLs are
+refused unless a webhook secret has been set (see SetWebhookSecret).
+The response to this request is the id of the stored hash. By default, ids are sequential integers;
+see SetIdMode for opaque ids. Wherever a hash id is accepted, either kind of id may be given.
+If the store is a model.CapacityLimiter without room for another hash, the request is refused
+with 507 Insufficient Storage.
 
 A GET request to '/hash/N' where N is a stored hash ID will respond with the saved hash.
+If hash N has been requested but not yet stored, the response is instead 202 Accepted, with a
+JSON HashStatus giving the time it is expected to be stored, and a Retry-After header.
+Only ids that were never assigned, or whose hash was deleted, get 404 Not Found.
+If the request has a "wait" query parameter, such as "wait=10s", a request for a pending hash
+instead waits until the hash is stored, for at most that long or MaxWait, and then responds
+as above. Waiting requests are released when the handler is shut down.
+The algorithm that produced the hash is reported in the X-Hash-Algorithm response header.
+If the request has the query parameter "format=phc", or accepts the PHCContentType media type,
+the response is instead a self-describing PHC string (see FormatPHC).
+Otherwise the hash is encoded as named by the "encoding" query parameter, or by an accepted
+media type of the form application/x-hash-<encoding>; see Encodings for the supported names.
+The default encoding is DefaultEncoding, and the encoding used is reported in the
+X-Hash-Encoding response header.
+
+A GET request to '/hash?ids=N,M,...' looks up many hashes at once. The response is a JSON object
+mapping each requested id to a HashStatus, which distinguishes ids that are unknown ("missing"),
+ids whose hash has not yet been computed ("pending"), and ids whose hash is stored ("completed").
+Completed hashes are formatted as for '/hash/N'.
+
+A GET request to '/hash' without ids lists the hashes the handler holds, a page at a time;
+see handleList for the parameters. Hashes, and hashes with public ids, are only included in the
+listing for requests with admin authorization (see SetAdminAuthorizer).
+
+A GET request to '/hash/N/deliveries' responds with the JSON WebhookDelivery recording the
+attempts to send the webhook for hash N, if it was requested with a callback URL. The callback
+URL itself, which may hold secrets of the client that gave it, is only included for requests
+with admin authorization.
+
+A DELETE request to '/hash/N' removes the stored hash N, responding with 204 No Content.
+If hash N has not been computed yet, the request cancels it, and no hash is ever stored under N.
+
+A hash whose time-to-live has elapsed is no longer served. A GET request for it responds with
+410 Gone, and a bulk lookup reports it as "expired". Expired hashes are deleted from the store
+by a background sweep, and the handler remembers their ids for ExpiredRetention afterwards.
+Once an expired id is forgotten, requests for it are answered as for a deleted hash.
 */
 type HashHandler struct {
-	nextId        int
-	nextIdMutex   sync.Mutex
-	keyStore      map[int]string
-	keyStoreMutex sync.Mutex
-	run           atomic.Value
-	delay         time.Duration
-	stats         *model.Stats
-	hasher        *Hasher
-	waitGroup     *sync.WaitGroup
+	nextId      atomic.Int64
+	reservedId  atomic.Int64
+	reserving   sync.Mutex
+	store       model.HashStore
+	stripes     idStripes
+	idMode      string
+	publicIds   sync.Map
+	isAdmin     func(request *http.Request) bool
+	events      *eventBroker
+	webhooks    *webhookSender
+	numPending  atomic.Int64
+	pendingSize atomic.Int64
+	admitting   sync.Mutex
+	run         atomic.Value
+	delay       time.Duration
+	ttl         time.Duration
+	sweepEvery  atomic.Int64
+	retention   time.Duration
+	stopped     chan struct{}
+	stopOnce    sync.Once
+	stats       *model.Stats
+	hasher      Hasher
+	iterations  int
+	keyring     *Keyring
+	waitGroup   *sync.WaitGroup
+}
+
+/*
+A HashStatus is used for marshaling the result of looking up one id of a bulk request to JSON,
+or the status of a pending hash, along with the time it is expected to be stored.
+*/
+type HashStatus struct {
+	Status    string     `json:"status"`
+	Algorithm string     `json:"algorithm,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+	Expected  *time.Time `json:"expected,omitempty"`
+}
+
+/*
+A pendingHash records when a hash was requested, when it is due to be stored,
+the algorithm that will compute it, and how long it is to be kept once stored.
+Closing cancel abandons the hash. The publicId, if any, is stored with the hash.
+The done channel is closed once the hash is no longer pending.
+A webhook is sent to callbackURL, if any, once the hash is stored.
+The size is that of the record once stored, as counted against the store's capacity.
+*/
+type pendingHash struct {
+	created     time.Time
+	due         time.Time
+	algorithm   string
+	ttl         time.Duration
+	cancel      chan struct{}
+	publicId    string
+	done        chan struct{}
+	callbackURL string
+	size        int64
 }
 
 /*
 NewHashHandler initializes and returns a new HashHandler.
-It will log request statistics to stats.
+It will keep hashes in store, and log request statistics to stats.
+New ids continue from the highest id already in store. If store is a model.IdTracker, ids are
+reserved in it before they are assigned, so that ids given out but never stored are not assigned
+again after a restart.
+If store is a model.EvictionNotifier, its evictions are counted in stats.
 When the handler begins processing delayed requests, it will add them to waitGroup so that
 shutdown may be delayed until each request has completed.
+The handler sweeps expired hashes from store in the background until it is shut down.
 */
-func NewHashHandler(stats *model.Stats, waitGroup *sync.WaitGroup) *HashHandler {
+func NewHashHandler(stats *model.Stats, store model.HashStore, waitGroup *sync.WaitGroup) *HashHandler {
 	h := new(HashHandler)
-	h.keyStore = make(map[int]string)
+	h.store = store
+	h.nextId.Store(int64(model.LastId(store)))
+	h.reservedId.Store(h.nextId.Load())
+	h.stripes = newIdStripes(idStripeCount)
+	h.idMode = IdModeInteger
+	h.indexPublicIds()
 	h.run.Store(true)
 	h.delay = 5 * time.Second
+	h.sweepEvery.Store(int64(DefaultSweepInterval))
+	h.retention = ExpiredRetention
+	h.stopped = make(chan struct{})
 	h.stats = stats
 	h.hasher = NewHasher()
+	h.iterations = DefaultIterations
 	h.waitGroup = waitGroup
+	h.events = newEventBroker(DefaultEventBufferSize)
+	h.webhooks = newWebhookSender()
+	if notifier, ok := store.(model.EvictionNotifier); ok {
+		notifier.OnEvict(func(id int, record model.HashRecord) {
+			stats.AddEviction()
+			if record.PublicId != "" {
+				h.publicIds.Delete(record.PublicId)
+			}
+			h.events.publish(EventDeleted, hashIdOf(id, record.PublicId))
+		})
+	}
+	go h.sweepExpiredPeriodically()
 	return h
 }
 
@@ -65,12 +205,14 @@ func (h *HashHandler) HandleRequest(w http.ResponseWriter, request *http.Request
 
 		} else if request.Method == "GET" {
 			err = h.handleGet(w, request)
+		} else if request.Method == "DELETE" {
+			err = h.handleDelete(w, request)
 		} else {
 			err = errors.New(fmt.Sprintf("unsupported request type: %s", request.Method))
 		}
 		if err != nil {
 			log.Println(err)
-			http.NotFound(w, request)
+			writeError(w, request, err)
 		}
 	} else {
 		w.WriteHeader(http.StatusServiceUnavailable)
@@ -79,10 +221,14 @@ func (h *HashHandler) HandleRequest(w http.ResponseWriter, request *http.Request
 }
 
 /*
-Shutdown disables further handling of requests by this handler.
+Shutdown disables further handling of requests by this handler, stops sweeping expired hashes,
+and releases any requests waiting for a hash to be stored.
 */
 func (h *HashHandler) Shutdown() {
 	h.run.Store(false)
+	h.stopOnce.Do(func() {
+		close(h.stopped)
+	})
 }
 
 /*
@@ -92,64 +238,835 @@ func (h *HashHandler) SetDelay(t time.Duration) {
 	h.delay = t
 }
 
-func (h *HashHandler) getHash(id int) string {
-	h.keyStoreMutex.Lock()
-	defer h.keyStoreMutex.Unlock()
-	return h.keyStore[id]
+/*
+SetTTL modifies how long hashes are kept once stored, for requests that do not specify a
+time-to-live. The default of 0 keeps hashes indefinitely.
+*/
+func (h *HashHandler) SetTTL(ttl time.Duration) error {
+	if ttl < 0 {
+		return errors.New(fmt.Sprintf("invalid time-to-live: %s", ttl))
+	}
+	h.ttl = ttl
+	return nil
+}
+
+/*
+SetSweepInterval modifies how often the handler scans for and deletes expired hashes.
+The default interval is DefaultSweepInterval. A new interval takes effect after the next sweep.
+*/
+func (h *HashHandler) SetSweepInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New(fmt.Sprintf("invalid sweep interval: %s", interval))
+	}
+	h.sweepEvery.Store(int64(interval))
+	return nil
+}
+
+/*
+SetDefaultAlgorithm selects the hash algorithm used by requests that do not specify one.
+The default algorithm is DefaultAlgorithm.
+*/
+func (h *HashHandler) SetDefaultAlgorithm(algorithm string) error {
+	hasher, err := GetHasher(algorithm)
+	if err != nil {
+		return err
+	}
+	h.hasher = hasher
+	return nil
+}
+
+/*
+SetIterations modifies the iteration count used by salted hash algorithms.
+The default iteration count is DefaultIterations.
+*/
+func (h *HashHandler) SetIterations(iterations int) error {
+	if iterations < 1 {
+		return errors.New(fmt.Sprintf("invalid iteration count: %d", iterations))
+	}
+	h.iterations = iterations
+	return nil
+}
+
+/*
+SetKeyring enables peppered hashing. Each new hash is computed from the HMAC of the password
+under the keyring's active key, and records the ID of that key.
+By default, no keyring is used and hashes are not peppered.
+*/
+func (h *HashHandler) SetKeyring(keyring *Keyring) {
+	h.keyring = keyring
+}
+
+func (h *HashHandler) getHash(id int) []byte {
+	return h.getRecord(id).Hash
+}
+
+func (h *HashHandler) getRecord(id int) model.HashRecord {
+	record, _ := h.store.Get(id)
+	return record
+}
+
+/*
+getStatus returns the record stored under id, if any, and whether the id is missing, pending,
+completed or expired. The record of an expired id is not returned.
+*/
+func (h *HashHandler) getStatus(id int) (model.HashRecord, string) {
+	stripe := h.stripes.get(id)
+	stripe.mutex.RLock()
+	defer stripe.mutex.RUnlock()
+	if record, ok := h.store.Get(id); ok {
+		if record.IsExpired(time.Now()) {
+			return model.HashRecord{}, StatusExpired
+		}
+		return record, StatusCompleted
+	}
+	if _, ok := stripe.pending[id]; ok {
+		return model.HashRecord{}, StatusPending
+	}
+	if _, ok := stripe.expired[id]; ok {
+		return model.HashRecord{}, StatusExpired
+	}
+	return model.HashRecord{}, StatusMissing
+}
+
+/*
+getPending returns the pendingHash for id, if its hash has not been stored yet.
+*/
+func (h *HashHandler) getPending(id int) (pendingHash, bool) {
+	stripe := h.stripes.get(id)
+	stripe.mutex.RLock()
+	defer stripe.mutex.RUnlock()
+	pending, ok := stripe.pending[id]
+	return pending, ok
+}
+
+/*
+lookupRecord returns the completed record stored under id, or an error suitable for the client
+if there is none.
+*/
+func (h *HashHandler) lookupRecord(id int) (model.HashRecord, error) {
+	record, status := h.getStatus(id)
+	switch status {
+	case StatusCompleted:
+		return record, nil
+	case StatusExpired:
+		return record, newRequestError(http.StatusGone, errors.New(fmt.Sprintf("hash %d has expired", id)))
+	}
+	return record, errors.New("failed hash lookup")
+}
+
+/*
+getNextHashId assigns a new id, and marks it as pending until a record made with algorithm is
+stored under it. The record will be kept for ttl once stored, or for the handler's default
+time-to-live if ttl is 0. If callbackURL is not empty, a webhook is sent to it once the record is stored.
+*/
+func (h *HashHandler) getNextHashId(algorithm string, ttl time.Duration, callbackURL string) int {
+	id := int(h.nextId.Add(1))
+	h.reserveId(id)
+	if ttl == 0 {
+		ttl = h.ttl
+	}
+	stripe := h.stripes.get(id)
+	stripe.mutex.Lock()
+	defer stripe.mutex.Unlock()
+	now := time.Now()
+	publicId := ""
+	if h.idMode == IdModeUUID {
+		publicId = newUUIDv7(now)
+		h.publicIds.Store(publicId, id)
+	}
+	size := h.recordSizeOf(algorithm)
+	stripe.pending[id] = pendingHash{now, now.Add(h.delay), algorithm, ttl, make(chan struct{}), publicId,
+		make(chan struct{}), callbackURL, size}
+	h.numPending.Add(1)
+	h.pendingSize.Add(size)
+	h.events.publish(EventCreated, hashIdOf(id, publicId))
+	return id
+}
+
+/*
+admit assigns a new id as getNextHashId does, if the store has room for another hash in addition
+to those pending, and otherwise returns an error. Concurrent admissions are serialized, so that
+each is checked against all the hashes admitted before it.
+*/
+func (h *HashHandler) admit(algorithm string, ttl time.Duration, callbackURL string) (int, error) {
+	h.admitting.Lock()
+	defer h.admitting.Unlock()
+	if err := h.checkRoom(algorithm); err != nil {
+		return 0, err
+	}
+	return h.getNextHashId(algorithm, ttl, callbackURL), nil
+}
+
+/*
+checkRoom returns an error if the store cannot hold another hash made with algorithm in addition
+to those pending.
+*/
+func (h *HashHandler) checkRoom(algorithm string) error {
+	limiter, ok := h.store.(model.CapacityLimiter)
+	if !ok {
+		return nil
+	}
+	if !limiter.HasRoom(int(h.numPending.Load())+1, h.pendingSize.Load()+h.recordSizeOf(algorithm)) {
+		return newRequestError(http.StatusInsufficientStorage, errors.New("no room to store another hash"))
+	}
+	return nil
 }
 
-func (h *HashHandler) getNextHashId() int {
-	h.nextIdMutex.Lock()
-	defer h.nextIdMutex.Unlock()
-	h.nextId++
-	return h.nextId
+/*
+recordSizeOf returns the size, as given by model.RecordSize, of a record made with algorithm
+under the active pepper key, if any.
+*/
+func (h *HashHandler) recordSizeOf(algorithm string) int64 {
+	record := model.HashRecord{Algorithm: algorithm}
+	if h.keyring != nil {
+		record.KeyId = h.keyring.ActiveKeyId()
+	}
+	if hasher, err := GetHasher(algorithm); err == nil {
+		record.Hash = make([]byte, hashSize(hasher))
+		if _, ok := hasher.(SaltedHasher); ok {
+			record.Salt = make([]byte, SaltLength)
+		}
+	}
+	return model.RecordSize(record)
+}
+
+/*
+takePending removes id from the pending hashes, and returns its pendingHash if it had one.
+It reports false if the hash was cancelled, in which case it must not be stored.
+The stripe holding id must be locked by the caller.
+*/
+func (h *HashHandler) takePending(stripe *idStripe, id int) (pendingHash, bool) {
+	if stripe.cancelled[id] {
+		delete(stripe.cancelled, id)
+		return pendingHash{}, false
+	}
+	pending, ok := stripe.pending[id]
+	if ok {
+		delete(stripe.pending, id)
+		h.numPending.Add(-1)
+		h.pendingSize.Add(-pending.size)
+		close(pending.done)
+	}
+	return pending, true
+}
+
+/*
+waitUntilDue sleeps for the handler's delay, unless the pending hash id is cancelled first.
+It reports whether the hash should still be computed and stored.
+*/
+func (h *HashHandler) waitUntilDue(id int) bool {
+	stripe := h.stripes.get(id)
+	stripe.mutex.Lock()
+	if stripe.cancelled[id] {
+		defer stripe.mutex.Unlock()
+		h.takePending(stripe, id)
+		return false
+	}
+	cancel := stripe.pending[id].cancel
+	stripe.mutex.Unlock()
+	timer := time.NewTimer(h.delay)
+	select {
+	case <-timer.C:
+		return true
+	case <-cancel:
+		timer.Stop()
+		stripe.mutex.Lock()
+		defer stripe.mutex.Unlock()
+		h.takePending(stripe, id)
+		return false
+	}
+}
+
+/*
+deleteHash removes the record stored under id, or cancels the hash if it is still pending.
+*/
+func (h *HashHandler) deleteHash(id int) error {
+	stripe := h.stripes.get(id)
+	stripe.mutex.Lock()
+	defer stripe.mutex.Unlock()
+	if pending, ok := stripe.pending[id]; ok {
+		delete(stripe.pending, id)
+		h.numPending.Add(-1)
+		h.pendingSize.Add(-pending.size)
+		stripe.cancelled[id] = true
+		close(pending.cancel)
+		close(pending.done)
+		h.publicIds.Delete(pending.publicId)
+		h.events.publish(EventDeleted, hashIdOf(id, pending.publicId))
+		return nil
+	}
+	if record, ok := model.Peek(h.store, id); ok {
+		if err := h.store.Delete(id); err != nil {
+			return err
+		}
+		h.publicIds.Delete(record.PublicId)
+		h.events.publish(EventDeleted, hashIdOf(id, record.PublicId))
+		return nil
+	}
+	if _, ok := stripe.expired[id]; ok {
+		return newRequestError(http.StatusGone, errors.New(fmt.Sprintf("hash %d has expired", id)))
+	}
+	return errors.New(fmt.Sprintf("failed hash deletion: no hash %d", id))
 }
 
 func (h *HashHandler) handlePost(w http.ResponseWriter, request *http.Request) error {
 	startTime := time.Now()
 	request.ParseForm()
-	password := request.PostForm.Get("password")
-	if password == "" {
-		return errors.New("malformed request: empty 'password' field")
+	var ttl time.Duration
+	if reqTTL := request.PostForm.Get("ttl"); reqTTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(reqTTL)
+		if err != nil || ttl <= 0 {
+			return newRequestError(http.StatusBadRequest,
+				errors.New(fmt.Sprintf("malformed request: invalid 'ttl' field: %s", reqTTL)))
+		}
 	}
-	nextId := h.getNextHashId()
-	h.waitGroup.Add(1)
-	go h.delayedHash(nextId, password)
-	io.WriteString(w, strconv.Itoa(nextId))
+	var callbackURL string
+	if reqCallbackURL := request.PostForm.Get("callback_url"); reqCallbackURL != "" {
+		var err error
+		callbackURL, err = h.parseCallbackURL(reqCallbackURL)
+		if err != nil {
+			return err
+		}
+	}
+	nextId, err := h.submitHash(request.PostForm.Get("password"), request.PostForm.Get("algorithm"), ttl, callbackURL)
+	if err != nil {
+		return err
+	}
+	io.WriteString(w, string(h.externalId(nextId)))
 	processingTime := time.Now().Sub(startTime)
 	h.stats.AddRequest(processingTime)
 	return nil
 }
 
+/*
+submitHash validates a request to hash password with the named algorithm, or with the handler's
+default algorithm if algorithm is empty. If the request is valid, it assigns an id and schedules
+the hash to be computed after the handler's delay. The hash is kept for ttl, or for the handler's
+default time-to-live if ttl is 0. Once it is stored, a webhook is sent to callbackURL, if it is not
+empty. It returns the assigned id.
+*/
+func (h *HashHandler) submitHash(password string, algorithm string, ttl time.Duration, callbackURL string) (int, error) {
+	if password == "" {
+		return 0, errors.New("malformed request: empty 'password' field")
+	}
+	hasher := h.hasher
+	if algorithm != "" {
+		var err error
+		hasher, err = GetHasher(algorithm)
+		if err != nil {
+			return 0, err
+		}
+	}
+	nextId, err := h.admit(hasher.Algorithm(), ttl, callbackURL)
+	if err != nil {
+		return 0, err
+	}
+	h.waitGroup.Add(1)
+	go h.delayedHash(nextId, password, hasher)
+	return nextId, nil
+}
+
 func (h *HashHandler) handleGet(w http.ResponseWriter, request *http.Request) error {
 	path := request.URL.Path
 	elements := strings.Split(path, "/")
 	numElements := len(elements)
-	if numElements == 3 {
-		reqId := elements[numElements-1]
-		id, err := strconv.Atoi(reqId)
+	if numElements == 2 && request.URL.Query().Has("ids") {
+		return h.handleBulkGet(w, request)
+	} else if numElements == 2 {
+		return h.handleList(w, request)
+	} else if numElements == 3 {
+		wait, err := requestedWait(request)
 		if err != nil {
-			return errors.New(fmt.Sprintf("malformed request: non-integer request id: %s", reqId))
+			return err
+		}
+		id, err := h.resolveId(elements[numElements-1])
+		if err != nil {
+			return err
+		}
+		pending, ok := h.getPending(id)
+		if ok && wait > 0 {
+			h.awaitHash(pending, wait)
+			pending, ok = h.getPending(id)
 		}
-		hash := h.getHash(id)
-		if hash == "" {
-			return errors.New("failed hash lookup")
+		if ok {
+			return writePending(w, pending)
+		}
+		record, err := h.lookupRecord(id)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("X-Hash-Algorithm", record.Algorithm)
+		hash, err := formatHash(request, record)
+		if err != nil {
+			return err
+		}
+		if wantsPHC(request) {
+			w.Header().Set("Content-Type", PHCContentType)
+		} else {
+			w.Header().Set("X-Hash-Encoding", requestedEncoding(request))
 		}
 		io.WriteString(w, hash)
+	} else if numElements == 4 && elements[3] == "deliveries" {
+		return h.handleDeliveries(w, request, elements[2])
 	} else {
 		return errors.New(fmt.Sprintf("malformed request: '%v'\n", path))
 	}
 	return nil
 }
 
-func (h *HashHandler) delayedHash(id int, pwd string) {
-	time.Sleep(h.delay)
-	h.processHash(id, pwd)
+/*
+requestedWait returns the duration given by the request's "wait" query parameter, if any,
+limited to MaxWait.
+*/
+func requestedWait(request *http.Request) (time.Duration, error) {
+	reqWait := request.URL.Query().Get("wait")
+	if reqWait == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(reqWait)
+	if err != nil || wait < 0 {
+		return 0, newRequestError(http.StatusBadRequest,
+			errors.New(fmt.Sprintf("malformed request: invalid 'wait' parameter: %s", reqWait)))
+	}
+	if wait > MaxWait {
+		wait = MaxWait
+	}
+	return wait, nil
+}
+
+/*
+awaitHash blocks until pending is no longer pending, wait has elapsed, or the handler is shut down.
+*/
+func (h *HashHandler) awaitHash(pending pendingHash, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-pending.done:
+	case <-timer.C:
+	case <-h.stopped:
+	}
+}
+
+/*
+writePending responds that a hash has not been computed yet, with 202 Accepted and a HashStatus
+giving the time it is expected to be stored. The Retry-After header gives the number of seconds
+until then, or 1 if that time has passed.
+*/
+func writePending(w http.ResponseWriter, pending pendingHash) error {
+	output, err := json.Marshal(HashStatus{Status: StatusPending, Expected: &pending.due})
+	if err != nil {
+		return err
+	}
+	retryAfter := int(math.Ceil(time.Until(pending.due).Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(output)
+	return nil
+}
+
+func (h *HashHandler) handleDelete(w http.ResponseWriter, request *http.Request) error {
+	elements := strings.Split(request.URL.Path, "/")
+	if len(elements) != 3 {
+		return errors.New(fmt.Sprintf("malformed request: '%v'\n", request.URL.Path))
+	}
+	id, err := h.resolveId(elements[2])
+	if err != nil {
+		return err
+	}
+	if err := h.deleteHash(id); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *HashHandler) handleBulkGet(w http.ResponseWriter, request *http.Request) error {
+	reqIds := strings.Split(request.URL.Query().Get("ids"), ",")
+	if len(reqIds) > DefaultMaxBulkIds {
+		return newRequestError(http.StatusRequestEntityTooLarge,
+			errors.New(fmt.Sprintf("lookup of %d ids exceeds limit of %d", len(reqIds), DefaultMaxBulkIds)))
+	}
+	statuses := make(map[string]HashStatus, len(reqIds))
+	for _, reqId := range reqIds {
+		id, err := h.resolveId(reqId)
+		if err != nil {
+			return newRequestError(http.StatusBadRequest, err)
+		}
+		record, status := h.getStatus(id)
+		hashStatus := HashStatus{Status: status}
+		if status == StatusCompleted {
+			hashStatus.Algorithm = record.Algorithm
+			hashStatus.Hash, err = formatHash(request, record)
+			if err != nil {
+				return err
+			}
+		}
+		statuses[reqId] = hashStatus
+	}
+	output, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+	return nil
+}
+
+/*
+formatHash returns the hash of record as a PHC string or in an encoding, as selected by request.
+*/
+func formatHash(request *http.Request, record model.HashRecord) (string, error) {
+	if wantsPHC(request) {
+		return FormatPHC(record), nil
+	}
+	return Encode(requestedEncoding(request), record.Hash)
+}
+
+func (h *HashHandler) delayedHash(id int, pwd string, hasher Hasher) {
+	if h.waitUntilDue(id) {
+		h.processHash(id, pwd, hasher)
+	}
+	h.waitGroup.Done()
+}
+
+func (h *HashHandler) processHash(id int, pwd string, hasher Hasher) {
+	record, err := h.computeRecord(pwd, hasher)
+	if err != nil {
+		log.Println(err)
+		stripe := h.stripes.get(id)
+		stripe.mutex.Lock()
+		h.takePending(stripe, id)
+		stripe.mutex.Unlock()
+		return
+	}
+	h.storeRecord(id, record)
+}
+
+/*
+submitRecord assigns an id to a record that has already been computed, and stores it after the
+handler's delay, as though it had been computed by a POST request. It returns the assigned id,
+or an error if the store has no room for the record.
+*/
+func (h *HashHandler) submitRecord(record model.HashRecord) (int, error) {
+	id, err := h.admit(record.Algorithm, 0, "")
+	if err != nil {
+		return 0, err
+	}
+	h.waitGroup.Add(1)
+	go h.delayedStore(id, record)
+	return id, nil
+}
+
+func (h *HashHandler) delayedStore(id int, record model.HashRecord) {
+	if h.waitUntilDue(id) {
+		h.storeRecord(id, record)
+	}
 	h.waitGroup.Done()
 }
 
-func (h *HashHandler) processHash(id int, pwd string) {
-	h.keyStoreMutex.Lock()
-	defer h.keyStoreMutex.Unlock()
-	h.keyStore[id] = h.hasher.Hash(pwd)
+/*
+storeRecord stores record under id, stamped with its request, completion and expiry times,
+unless the hash was cancelled while it was being computed. If the hash was requested with a
+callback URL, a webhook is then sent in the background.
+*/
+func (h *HashHandler) storeRecord(id int, record model.HashRecord) {
+	stripe := h.stripes.get(id)
+	stripe.mutex.Lock()
+	defer stripe.mutex.Unlock()
+	pending, ok := h.takePending(stripe, id)
+	if !ok {
+		return
+	}
+	record.Completed = time.Now()
+	record.Created = record.Completed
+	ttl := h.ttl
+	if !pending.created.IsZero() {
+		record.Created = pending.created
+		record.PublicId = pending.publicId
+		ttl = pending.ttl
+	}
+	if ttl > 0 {
+		record.Expires = record.Completed.Add(ttl)
+	}
+	err := h.store.Put(id, record)
+	if err != nil {
+		log.Printf("hash %d was not stored: %v\n", id, err)
+		return
+	}
+	h.events.publish(EventCompleted, hashIdOf(id, record.PublicId))
+	if pending.callbackURL != "" {
+		h.waitGroup.Add(1)
+		go h.notifyStored(id, pending.callbackURL, record)
+	}
+}
+
+func (h *HashHandler) computeRecord(pwd string, hasher Hasher) (model.HashRecord, error) {
+	return h.computeRecordWithIterations(pwd, hasher, h.iterations)
+}
+
+/*
+computeRecordWithIterations computes a record of pwd with hasher, using the given iteration count
+if hasher is salted.
+*/
+func (h *HashHandler) computeRecordWithIterations(pwd string, hasher Hasher, iterations int) (model.HashRecord, error) {
+	record := model.HashRecord{Algorithm: hasher.Algorithm()}
+	if h.keyring != nil {
+		record.KeyId = h.keyring.ActiveKeyId()
+	}
+	if _, ok := hasher.(SaltedHasher); ok {
+		record.Salt = make([]byte, SaltLength)
+		rand.Read(record.Salt)
+		record.Iterations = iterations
+	}
+	hash, err := h.recomputeHash(pwd, record)
+	if err != nil {
+		return record, err
+	}
+	record.Hash = hash
+	return record, nil
+}
+
+/*
+verify reports whether pwd produces the hash stored under id, recomputing the hash with the
+algorithm and parameters recorded when the hash was created.
+The comparison takes constant time with respect to the hash contents.
+
+When the password matches an outdated record, the record is replaced by a stronger one
+(see rehashPolicy), and the result reports that the record was rehashed.
+A record is never replaced by a weaker one.
+*/
+func (h *HashHandler) verify(id int, pwd string) (VerifyResult, error) {
+	result := VerifyResult{}
+	record, err := h.lookupRecord(id)
+	if err != nil {
+		return result, err
+	}
+	match, err := h.verifyRecord(pwd, record)
+	if err != nil || !match {
+		return result, err
+	}
+	result.Match = true
+	hasher, iterations, ok := h.rehashPolicy(record)
+	if !ok {
+		return result, nil
+	}
+	rehashed, err := h.computeRecordWithIterations(pwd, hasher, iterations)
+	if err != nil {
+		log.Println(err)
+		return result, nil
+	}
+	result.Rehashed = h.replaceRecord(id, record, rehashed)
+	return result, nil
+}
+
+/*
+rehashPolicy reports whether record should be rehashed on a successful verification, and with
+which hasher and iteration count. Only changes that keep the record at least as strong qualify:
+a record moves to the handler's algorithm if that ranks above its own (see algorithmStrength),
+or if the record is unsalted and the handler's algorithm is salted. Otherwise the record keeps
+its algorithm, and is rehashed if it was peppered with an inactive key, or if it is salted with
+fewer iterations than the handler's. A rehashed record never gets fewer iterations than it had.
+*/
+func (h *HashHandler) rehashPolicy(record model.HashRecord) (Hasher, int, bool) {
+	iterations := max(h.iterations, record.Iterations)
+	_, saltedDefault := h.hasher.(SaltedHasher)
+	if (record.Salt == nil && saltedDefault) || isStronger(h.hasher.Algorithm(), record.Algorithm) {
+		return h.hasher, iterations, true
+	}
+	hasher, err := GetHasher(record.Algorithm)
+	if err != nil {
+		return nil, 0, false
+	}
+	if h.keyring != nil && record.KeyId != h.keyring.ActiveKeyId() {
+		return hasher, iterations, true
+	}
+	if record.Salt != nil && record.Iterations < h.iterations {
+		return hasher, iterations, true
+	}
+	return nil, 0, false
+}
+
+/*
+replaceRecord stores replacement under id, provided that the stored record is still current.
+It reports whether the replacement was stored.
+*/
+func (h *HashHandler) replaceRecord(id int, current model.HashRecord, replacement model.HashRecord) bool {
+	stripe := h.stripes.get(id)
+	stripe.mutex.Lock()
+	defer stripe.mutex.Unlock()
+	stored, _ := h.store.Get(id)
+	if !bytes.Equal(stored.Hash, current.Hash) {
+		return false
+	}
+	replacement.Created = stored.Created
+	replacement.Expires = stored.Expires
+	replacement.PublicId = stored.PublicId
+	replacement.Completed = time.Now()
+	err := h.store.Put(id, replacement)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+/*
+sweepExpiredPeriodically calls sweepExpired once per sweep interval until the handler is shut down.
+*/
+func (h *HashHandler) sweepExpiredPeriodically() {
+	for {
+		timer := time.NewTimer(time.Duration(h.sweepEvery.Load()))
+		select {
+		case <-timer.C:
+			h.sweepExpired()
+		case <-h.stopped:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+/*
+sweepExpired deletes every stored record whose time-to-live has elapsed, remembers its id so that
+later requests for it can be told it expired, and counts it in the handler's statistics.
+Ids that expired longer ago than the handler's retention are forgotten.
+It returns the number of records deleted.
+*/
+func (h *HashHandler) sweepExpired() int {
+	now := time.Now()
+	h.forgetExpired(now.Add(-h.retention))
+	count := 0
+	for _, id := range h.store.List() {
+		if h.expireRecord(id, now) {
+			count++
+		}
+	}
+	if count > 0 {
+		h.stats.AddExpirations(count)
+	}
+	return count
+}
+
+/*
+expireRecord deletes the record stored under id if its time-to-live had elapsed by now,
+and reports whether it did.
+*/
+func (h *HashHandler) expireRecord(id int, now time.Time) bool {
+	stripe := h.stripes.get(id)
+	stripe.mutex.Lock()
+	defer stripe.mutex.Unlock()
+	record, ok := model.Peek(h.store, id)
+	if !ok || !record.IsExpired(now) {
+		return false
+	}
+	if err := h.store.Delete(id); err != nil {
+		log.Println(err)
+		return false
+	}
+	stripe.expired[id] = expiredHash{record.PublicId, now}
+	h.events.publish(EventExpired, hashIdOf(id, record.PublicId))
+	return true
+}
+
+/*
+forgetExpired forgets the ids, and public ids, of the hashes swept before cutoff.
+*/
+func (h *HashHandler) forgetExpired(cutoff time.Time) {
+	for _, stripe := range h.stripes {
+		stripe.mutex.Lock()
+		for id, expired := range stripe.expired {
+			if expired.swept.Before(cutoff) {
+				delete(stripe.expired, id)
+				if expired.publicId != "" {
+					h.publicIds.Delete(expired.publicId)
+				}
+			}
+		}
+		stripe.mutex.Unlock()
+	}
+}
+
+func (h *HashHandler) verifyRecord(pwd string, record model.HashRecord) (bool, error) {
+	hash, err := h.recomputeHash(pwd, record)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(hash, record.Hash) == 1, nil
+}
+
+func wantsPHC(request *http.Request) bool {
+	if request.URL.Query().Get("format") == "phc" {
+		return true
+	}
+	return strings.Contains(request.Header.Get("Accept"), PHCContentType)
+}
+
+/*
+recomputeHash computes the hash of pwd using the algorithm, salt, iteration count and pepper key
+named by record.
+*/
+func (h *HashHandler) recomputeHash(pwd string, record model.HashRecord) ([]byte, error) {
+	hasher, err := GetHasher(record.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if record.KeyId != "" {
+		if h.keyring == nil {
+			return nil, errors.New(fmt.Sprintf("no keyring for pepper key ID: %s", record.KeyId))
+		}
+		pwd, err = h.keyring.Pepper(record.KeyId, pwd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if salted, ok := hasher.(SaltedHasher); ok {
+		return salted.HashSalted(pwd, record.Salt, record.Iterations)
+	}
+	return hasher.Hash(pwd), nil
+}
+
+/*
+exportSnapshot writes a point-in-time snapshot of every stored record to w.
+Records are only written while the stripe holding their id is locked, so locking every stripe
+while copying the records makes the snapshot consistent. The records are written out after the
+stripes are unlocked.
+*/
+func (h *HashHandler) exportSnapshot(w io.Writer) error {
+	h.stripes.lockAll()
+	lastId := int(h.nextId.Load())
+	ids := h.store.List()
+	records := make([]model.HashRecord, len(ids))
+	for i, id := range ids {
+		records[i], _ = model.Peek(h.store, id)
+	}
+	h.stripes.unlockAll()
+	return model.WriteSnapshot(w, lastId, ids, records)
+}
+
+/*
+restoreSnapshot loads a snapshot written by exportSnapshot. The handler must not hold any
+records or pending hashes. Ids continue after the snapshot's last id.
+*/
+func (h *HashHandler) restoreSnapshot(r io.Reader) error {
+	h.stripes.lockAll()
+	defer h.stripes.unlockAll()
+	if h.store.Len() != 0 || h.numPending.Load() != 0 {
+		return newRequestError(http.StatusConflict, errors.New("cannot restore a snapshot into a server that holds hashes"))
+	}
+	header, err := model.RestoreStore(r, h.store)
+	if err != nil {
+		return newRequestError(http.StatusBadRequest, err)
+	}
+	h.indexPublicIds()
+	for {
+		lastId := h.nextId.Load()
+		if int64(header.LastId) <= lastId || h.nextId.CompareAndSwap(lastId, int64(header.LastId)) {
+			return nil
+		}
+	}
 }
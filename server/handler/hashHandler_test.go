@@ -2,7 +2,10 @@ package handler
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -13,25 +16,24 @@ import (
 func TestGetNonexistantHash(t *testing.T) {
 	stats := model.NewStats()
 	wg := new(sync.WaitGroup)
-	h := NewHashHandler(stats, wg)
+	h := NewHashHandler(stats, model.NewMemoryStore(), wg)
 	id := 77
 	retrieved := h.getHash(id)
-	expected := ""
-	if retrieved != expected {
-		t.Errorf("Expected %s, retrieved %s", expected, retrieved)
+	if len(retrieved) != 0 {
+		t.Errorf("Expected empty hash, retrieved %v", retrieved)
 	}
 }
 
 func TestAddGetHash(t *testing.T) {
 	stats := model.NewStats()
 	wg := new(sync.WaitGroup)
-	h := NewHashHandler(stats, wg)
+	h := NewHashHandler(stats, model.NewMemoryStore(), wg)
 	id := 77
 	pwd := "3.14159265358979"
 	setDelay := 1 * time.Millisecond
 	h.SetDelay(setDelay)
 	h.waitGroup.Add(1)
-	go h.delayedHash(id, pwd)
+	go h.delayedHash(id, pwd, h.hasher)
 	time.Sleep(setDelay * 2)
 	retrieved := h.getHash(id)
 	if len(retrieved) == 0 {
@@ -42,15 +44,15 @@ func TestAddGetHash(t *testing.T) {
 func TestGetHashTooQuickly(t *testing.T) {
 	stats := model.NewStats()
 	wg := new(sync.WaitGroup)
-	h := NewHashHandler(stats, wg)
+	h := NewHashHandler(stats, model.NewMemoryStore(), wg)
 	id := 77
 	pwd := "777"
 	setDelay := 10 * time.Millisecond
 	h.SetDelay(setDelay)
 	h.waitGroup.Add(1)
-	go h.delayedHash(id, pwd)
+	go h.delayedHash(id, pwd, h.hasher)
 	retrieved := h.getHash(id)
-	if retrieved != "" {
+	if len(retrieved) != 0 {
 		t.Errorf("Hash was processed before delay expired.")
 	}
 }
@@ -58,7 +60,7 @@ func TestGetHashTooQuickly(t *testing.T) {
 func TestHandlePost(t *testing.T) {
 	stats := model.NewStats()
 	wg := new(sync.WaitGroup)
-	h := NewHashHandler(stats, wg)
+	h := NewHashHandler(stats, model.NewMemoryStore(), wg)
 	writer := new(MockResponseWriter)
 	body := bytes.NewBufferString("malformed post")
 	req, err := http.NewRequest("POST", "http://12.34.56.78:4321/hash", body)
@@ -70,3 +72,419 @@ func TestHandlePost(t *testing.T) {
 		t.Errorf("Handler didn't write anything")
 	}
 }
+
+func TestAddGetHashRecordsAlgorithm(t *testing.T) {
+	stats := model.NewStats()
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(stats, model.NewMemoryStore(), wg)
+	if err := h.SetDefaultAlgorithm("sha3-256"); err != nil {
+		t.Fatalf("Failed to set default algorithm: %s", err)
+	}
+	id := 77
+	h.SetDelay(0)
+	h.waitGroup.Add(1)
+	h.delayedHash(id, "777", h.hasher)
+	retrieved := h.getRecord(id)
+	if retrieved.Algorithm != "sha3-256" {
+		t.Errorf("Expected algorithm %s, got %s", "sha3-256", retrieved.Algorithm)
+	}
+}
+
+func TestSaltedHashesDiffer(t *testing.T) {
+	stats := model.NewStats()
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(stats, model.NewMemoryStore(), wg)
+	h.SetIterations(1000)
+	hasher, _ := GetHasher("pbkdf2-sha512")
+	pwd := "777"
+	h.processHash(1, pwd, hasher)
+	h.processHash(2, pwd, hasher)
+	first := h.getRecord(1)
+	second := h.getRecord(2)
+	if first.Iterations != 1000 || len(first.Salt) != SaltLength {
+		t.Errorf("Expected salt and iterations to be stored, got %v", first)
+	}
+	if bytes.Equal(first.Hash, second.Hash) {
+		t.Errorf("Identical passwords produced identical salted hashes")
+	}
+}
+
+func TestBulkGet(t *testing.T) {
+	stats := model.NewStats()
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(stats, model.NewMemoryStore(), wg)
+	h.SetDelay(0)
+	completed, _ := h.submitHash("777", "sha256", 0, "")
+	wg.Wait()
+	h.SetDelay(time.Hour)
+	pending := h.getNextHashId("", 0, "")
+	url := fmt.Sprintf("http://12.34.56.78:4321/hash?ids=%d,%d,99&encoding=hex", completed, pending)
+	req, _ := http.NewRequest("GET", url, nil)
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	expected := `{"1":{"status":"completed","algorithm":"sha256","hash":"eaf89db7108470dc3f6b23ea90618264b3e8f8b6145371667c4055e9c5ce9f52"},"2":{"status":"pending"},"99":{"status":"missing"}}`
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+}
+
+func TestBulkGetMalformed(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	req, _ := http.NewRequest("GET", "http://12.34.56.78:4321/hash?ids=1,x", nil)
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, writer.LastStatus)
+	}
+}
+
+func TestIdsContinueFromStore(t *testing.T) {
+	store := model.NewMemoryStore()
+	store.Put(41, model.HashRecord{Algorithm: "sha256", Hash: []byte{1}})
+	h := NewHashHandler(model.NewStats(), store, new(sync.WaitGroup))
+	if id := h.getNextHashId("", 0, ""); id != 42 {
+		t.Errorf("Expected id %d, got %d", 42, id)
+	}
+}
+
+func TestHandlePostTTL(t *testing.T) {
+	stats := model.NewStats()
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(stats, model.NewMemoryStore(), wg)
+	h.SetDelay(0)
+	h.SetTTL(time.Hour)
+	for _, form := range []string{"password=a", "password=b&ttl=1ms"} {
+		req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/hash", bytes.NewBufferString(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.HandleRequest(new(MockResponseWriter), req)
+	}
+	wg.Wait()
+	if ttl := h.getRecord(1).Expires.Sub(h.getRecord(1).Completed); ttl != time.Hour {
+		t.Errorf("Expected default time-to-live %s, got %s", time.Hour, ttl)
+	}
+	if ttl := h.getRecord(2).Expires.Sub(h.getRecord(2).Completed); ttl != time.Millisecond {
+		t.Errorf("Expected requested time-to-live %s, got %s", time.Millisecond, ttl)
+	}
+
+	req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/hash", bytes.NewBufferString("password=c&ttl=-1s"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a negative ttl, got %d", http.StatusBadRequest, writer.LastStatus)
+	}
+}
+
+func TestGetExpiredHash(t *testing.T) {
+	stats := model.NewStats()
+	store := model.NewMemoryStore()
+	h := NewHashHandler(stats, store, new(sync.WaitGroup))
+	past := time.Now().Add(-time.Second)
+	store.Put(1, model.HashRecord{Algorithm: "sha256", Hash: []byte{1}, Expires: past})
+	store.Put(2, model.HashRecord{Algorithm: "sha256", Hash: []byte{2}, Expires: past})
+	store.Put(3, model.HashRecord{Algorithm: "sha256", Hash: []byte{3}, Expires: time.Now().Add(time.Hour)})
+
+	req, _ := http.NewRequest("GET", "http://12.34.56.78:4321/hash/1", nil)
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusGone {
+		t.Errorf("Expected status %d before sweep, got %d", http.StatusGone, writer.LastStatus)
+	}
+
+	if count := h.sweepExpired(); count != 2 {
+		t.Errorf("Expected %d hashes to expire, got %d", 2, count)
+	}
+	if store.Len() != 1 || stats.GetStats().Expired != 2 {
+		t.Errorf("Expected 1 hash left and 2 expirations, had %d and %d", store.Len(), stats.GetStats().Expired)
+	}
+	writer = new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusGone {
+		t.Errorf("Expected status %d after sweep, got %d", http.StatusGone, writer.LastStatus)
+	}
+
+	req, _ = http.NewRequest("GET", "http://12.34.56.78:4321/hash?ids=2,3&encoding=hex", nil)
+	writer = new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	expected := `{"2":{"status":"expired"},"3":{"status":"completed","algorithm":"sha256","hash":"03"}}`
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+}
+
+func TestExpiredIdsForgotten(t *testing.T) {
+	store := model.NewMemoryStore()
+	h := NewHashHandler(model.NewStats(), store, new(sync.WaitGroup))
+	h.retention = time.Millisecond
+	publicId := newUUIDv7(time.Now())
+	store.Put(1, model.HashRecord{Algorithm: "sha256", Hash: []byte{1}, Expires: time.Now(), PublicId: publicId})
+	h.publicIds.Store(publicId, 1)
+	h.sweepExpired()
+	req, _ := http.NewRequest("GET", "http://12.34.56.78:4321/hash/"+publicId, nil)
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusGone {
+		t.Errorf("Expected status %d after sweep, got %d", http.StatusGone, writer.LastStatus)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	h.sweepExpired()
+	writer = new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusNotFound {
+		t.Errorf("Expected status %d once forgotten, got %d", http.StatusNotFound, writer.LastStatus)
+	}
+	if _, ok := h.publicIds.Load(publicId); ok {
+		t.Errorf("Expected public id %s to be forgotten", publicId)
+	}
+}
+
+func TestSweeperStopsOnShutdown(t *testing.T) {
+	store := model.NewMemoryStore()
+	h := NewHashHandler(model.NewStats(), store, new(sync.WaitGroup))
+	h.SetSweepInterval(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	store.Put(1, model.HashRecord{Algorithm: "sha256", Hash: []byte{1}, Expires: time.Now()})
+	deadline := time.Now().Add(time.Second)
+	for store.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if store.Len() != 0 {
+		t.Errorf("Expired hash was not swept")
+	}
+	h.Shutdown()
+	h.Shutdown()
+}
+
+func deleteHash(t *testing.T, h *HashHandler, id int) *MockResponseWriter {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("http://12.34.56.78:4321/hash/%d", id), nil)
+	if err != nil {
+		t.Fatalf("Failed to construct DELETE request")
+	}
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	return writer
+}
+
+func TestDeleteCompletedHash(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), wg)
+	h.SetDelay(0)
+	id, _ := h.submitHash("777", "sha256", 0, "")
+	wg.Wait()
+	if writer := deleteHash(t, h, id); writer.LastStatus != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, writer.LastStatus)
+	}
+	if _, status := h.getStatus(id); status != StatusMissing {
+		t.Errorf("Expected deleted hash to be %s, was %s", StatusMissing, status)
+	}
+	if writer := deleteHash(t, h, id); writer.LastStatus != http.StatusNotFound {
+		t.Errorf("Expected status %d deleting again, got %d", http.StatusNotFound, writer.LastStatus)
+	}
+}
+
+func TestDeletePendingHash(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	store := model.NewMemoryStore()
+	h := NewHashHandler(model.NewStats(), store, wg)
+	h.SetDelay(time.Hour)
+	id, _ := h.submitHash("777", "sha256", 0, "")
+	if writer := deleteHash(t, h, id); writer.LastStatus != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, writer.LastStatus)
+	}
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Cancelled hash was still in the wait group")
+	}
+	if _, status := h.getStatus(id); status != StatusMissing || store.Len() != 0 {
+		t.Errorf("Expected cancelled hash to be %s, was %s", StatusMissing, status)
+	}
+	if len(h.stripes.get(id).cancelled) != 0 {
+		t.Errorf("Cancellation was not cleared: %v", h.stripes.get(id).cancelled)
+	}
+}
+
+func TestDeleteHashBeingComputed(t *testing.T) {
+	store := model.NewMemoryStore()
+	h := NewHashHandler(model.NewStats(), store, new(sync.WaitGroup))
+	id := h.getNextHashId("", 0, "")
+	record, _ := h.computeRecord("777", h.hasher)
+	if err := h.deleteHash(id); err != nil {
+		t.Fatalf("Delete produced error %s", err)
+	}
+	h.storeRecord(id, record)
+	if store.Len() != 0 || len(h.stripes.get(id).cancelled) != 0 {
+		t.Errorf("Cancelled hash was stored")
+	}
+}
+
+func TestStoreLimits(t *testing.T) {
+	stats := model.NewStats()
+	wg := new(sync.WaitGroup)
+	store, _ := model.NewBoundedStore(model.NewMemoryStore(), model.BoundedStoreOptions{MaxRecords: 1})
+	h := NewHashHandler(stats, store, wg)
+	h.SetDelay(0)
+	h.submitHash("a", "", 0, "")
+	h.submitHash("b", "", 0, "")
+	wg.Wait()
+	if store.Len() != 1 || stats.GetStats().Evicted != 1 {
+		t.Errorf("Expected 1 hash and 1 eviction, had %d and %d", store.Len(), stats.GetStats().Evicted)
+	}
+
+	store, _ = model.NewBoundedStore(model.NewMemoryStore(), model.BoundedStoreOptions{MaxRecords: 1})
+	h = NewHashHandler(stats, store, wg)
+	h.SetDelay(0)
+	h.SetIdMode(IdModeUUID)
+	first, _ := h.submitHash("a", "", 0, "")
+	wg.Wait()
+	publicId := h.publicIdOf(first)
+	h.submitHash("b", "", 0, "")
+	wg.Wait()
+	if _, ok := h.publicIds.Load(publicId); ok {
+		t.Errorf("Expected the public id of an evicted hash to be forgotten")
+	}
+
+	store, _ = model.NewBoundedStore(model.NewMemoryStore(), model.BoundedStoreOptions{MaxRecords: 1, Reject: true})
+	h = NewHashHandler(stats, store, wg)
+	h.SetDelay(time.Hour)
+	h.submitHash("a", "", 0, "")
+	req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/hash", bytes.NewBufferString("password=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusInsufficientStorage {
+		t.Errorf("Expected status %d, got %d", http.StatusInsufficientStorage, writer.LastStatus)
+	}
+	h.deleteHash(1)
+	wg.Wait()
+
+	// Room is kept for the pending hash at its full size, long key id included.
+	keyId := strings.Repeat("k", 100)
+	size := model.RecordSize(model.HashRecord{Algorithm: DefaultAlgorithm, Hash: make([]byte, 64), KeyId: keyId})
+	store, _ = model.NewBoundedStore(model.NewMemoryStore(),
+		model.BoundedStoreOptions{MaxBytes: 2*size - 1, Reject: true})
+	h = NewHashHandler(stats, store, wg)
+	keyring := NewKeyring()
+	keyring.AddKey(keyId, []byte("0123456789abcdef"))
+	h.SetKeyring(keyring)
+	h.SetDelay(time.Hour)
+	if _, err := h.submitHash("a", "", 0, ""); err != nil {
+		t.Fatalf("Expected room for one hash, got %s", err)
+	}
+	writer = new(MockResponseWriter)
+	req, _ = http.NewRequest("POST", "http://12.34.56.78:4321/hash", bytes.NewBufferString("password=b"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusInsufficientStorage {
+		t.Errorf("Expected status %d with the byte limit taken by a pending hash, got %d",
+			http.StatusInsufficientStorage, writer.LastStatus)
+	}
+	h.deleteHash(1)
+	wg.Wait()
+}
+
+func BenchmarkHandlePost(b *testing.B) {
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), wg)
+	h.SetDelay(0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/hash", bytes.NewBufferString("password=777&algorithm=sha256"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			h.HandleRequest(new(MockResponseWriter), req)
+		}
+	})
+	wg.Wait()
+}
+
+func BenchmarkHandleGet(b *testing.B) {
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), wg)
+	h.SetDelay(0)
+	for i := 0; i < 1000; i++ {
+		h.submitHash("777", "sha256", 0, "")
+	}
+	wg.Wait()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			req, _ := http.NewRequest("GET", fmt.Sprintf("http://12.34.56.78:4321/hash/%d", i%1000+1), nil)
+			h.HandleRequest(new(MockResponseWriter), req)
+			i++
+		}
+	})
+}
+
+func TestGetPendingHash(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), wg)
+	h.SetDelay(5 * time.Second)
+	id, _ := h.submitHash("777", "sha256", 0, "")
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://12.34.56.78:4321/hash/%d", id), nil)
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, writer.LastStatus)
+	}
+	if retryAfter := writer.LastHeaders.Get("Retry-After"); retryAfter != "5" {
+		t.Errorf("Expected Retry-After %s, got %s", "5", retryAfter)
+	}
+	var status HashStatus
+	if err := json.Unmarshal(writer.LastData, &status); err != nil || status.Status != StatusPending || status.Expected == nil {
+		t.Errorf("Expected pending status with expected time, got %s", writer.LastData)
+	} else if until := time.Until(*status.Expected); until <= 4*time.Second || until > 5*time.Second {
+		t.Errorf("Expected completion in about 5s, got %s", until)
+	}
+
+	h.deleteHash(id)
+	wg.Wait()
+	writer = new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusNotFound {
+		t.Errorf("Expected status %d after cancellation, got %d", http.StatusNotFound, writer.LastStatus)
+	}
+}
+
+func TestWaitForHash(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), wg)
+	h.SetDelay(50 * time.Millisecond)
+	id, _ := h.submitHash("777", "sha256", 0, "")
+	get := func(wait string) (*MockResponseWriter, time.Duration) {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("http://12.34.56.78:4321/hash/%d?wait=%s", id, wait), nil)
+		writer := new(MockResponseWriter)
+		start := time.Now()
+		h.HandleRequest(writer, req)
+		return writer, time.Since(start)
+	}
+	writer, elapsed := get("10s")
+	if writer.LastStatus != 0 || len(writer.LastData) == 0 || elapsed > 5*time.Second {
+		t.Errorf("Expected hash after about 50ms, got status %d after %s", writer.LastStatus, elapsed)
+	}
+	if writer, _ := get("soon"); writer.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d for bad wait, got %d", http.StatusBadRequest, writer.LastStatus)
+	}
+
+	h.SetDelay(time.Hour)
+	id, _ = h.submitHash("777", "sha256", 0, "")
+	if writer, elapsed := get("20ms"); writer.LastStatus != http.StatusAccepted || elapsed < 20*time.Millisecond {
+		t.Errorf("Expected status %d after 20ms, got %d after %s", http.StatusAccepted, writer.LastStatus, elapsed)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		h.Shutdown()
+	}()
+	if writer, elapsed := get("1m"); writer.LastStatus != http.StatusAccepted || elapsed > 5*time.Second {
+		t.Errorf("Expected shutdown to release wait with status %d, got %d after %s", http.StatusAccepted, writer.LastStatus, elapsed)
+	}
+	h.deleteHash(id)
+	wg.Wait()
+}
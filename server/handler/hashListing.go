@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+const (
+	// DefaultListLimit is the number of hashes in one page of a listing, unless the request sets a limit.
+	DefaultListLimit = 100
+	// MaxListLimit is the largest number of hashes a request may list in one page.
+	MaxListLimit = 1000
+)
+
+/*
+A HashListing is used for marshaling one page of a listing of hashes to JSON.
+Next is the cursor from which to continue the listing, and is omitted from the last page.
+*/
+type HashListing struct {
+	Hashes []HashSummary `json:"hashes"`
+	Next   string        `json:"next,omitempty"`
+}
+
+/*
+A HashSummary describes one hash in a listing. Timestamps that do not apply are omitted,
+as is the hash itself unless the listing was requested with admin authorization.
+*/
+type HashSummary struct {
+	Id        HashId     `json:"id"`
+	Status    string     `json:"status"`
+	Algorithm string     `json:"algorithm,omitempty"`
+	Created   *time.Time `json:"created,omitempty"`
+	Completed *time.Time `json:"completed,omitempty"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+}
+
+/*
+SetAdminAuthorizer sets the function that decides whether a request has admin authorization,
+which is required to include hashes, and hashes with public ids, in a listing.
+By default, no request has it.
+*/
+func (h *HashHandler) SetAdminAuthorizer(isAdmin func(request *http.Request) bool) {
+	h.isAdmin = isAdmin
+}
+
+/*
+handleList responds with one page of the pending, completed and expired hashes in the order they
+were requested, starting after the "after" cursor and holding at most "limit" hashes.
+The "status" and "algorithm" query parameters restrict the listing to matching hashes.
+Hashes with public ids are only listed for requests with admin authorization, since anyone who
+learns a public id can look up its hash, and listing them would make them enumerable after all.
+*/
+func (h *HashHandler) handleList(w http.ResponseWriter, request *http.Request) error {
+	query := request.URL.Query()
+	limit := DefaultListLimit
+	if reqLimit := query.Get("limit"); reqLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(reqLimit)
+		if err != nil || limit < 1 || limit > MaxListLimit {
+			return newRequestError(http.StatusBadRequest,
+				errors.New(fmt.Sprintf("malformed request: limit must be from 1 to %d: %s", MaxListLimit, reqLimit)))
+		}
+	}
+	after, err := decodeCursor(query.Get("after"))
+	if err != nil {
+		return newRequestError(http.StatusBadRequest, err)
+	}
+	status := query.Get("status")
+	if status != "" && status != StatusPending && status != StatusCompleted && status != StatusExpired {
+		return newRequestError(http.StatusBadRequest,
+			errors.New(fmt.Sprintf("malformed request: unknown status: %s", status)))
+	}
+	algorithm := query.Get("algorithm")
+	isAdmin := h.isAdmin != nil && h.isAdmin(request)
+
+	listing := HashListing{Hashes: []HashSummary{}}
+	ids := h.listIds(after)
+	for i, id := range ids {
+		summary, record, ok := h.summarize(id)
+		if !ok || (status != "" && summary.Status != status) || (algorithm != "" && summary.Algorithm != algorithm) {
+			continue
+		}
+		if !isAdmin && summary.Id != hashIdOf(id, "") {
+			continue
+		}
+		if isAdmin && summary.Status == StatusCompleted {
+			summary.Hash, err = formatHash(request, record)
+			if err != nil {
+				return err
+			}
+		}
+		listing.Hashes = append(listing.Hashes, summary)
+		if len(listing.Hashes) == limit {
+			if i < len(ids)-1 {
+				listing.Next = encodeCursor(id)
+			}
+			break
+		}
+	}
+	output, err := json.Marshal(listing)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+	return nil
+}
+
+/*
+listIds returns, in ascending order, every id greater than after that is pending, stored,
+or known to have expired.
+*/
+func (h *HashHandler) listIds(after int) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	add := func(id int) {
+		if id > after && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range h.store.List() {
+		add(id)
+	}
+	for _, stripe := range h.stripes {
+		stripe.mutex.RLock()
+		for id := range stripe.pending {
+			add(id)
+		}
+		for id := range stripe.expired {
+			add(id)
+		}
+		stripe.mutex.RUnlock()
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+/*
+summarize returns the summary of hash id without its hash, along with its record if it is stored.
+It reports false if there is no longer a hash with that id.
+*/
+func (h *HashHandler) summarize(id int) (HashSummary, model.HashRecord, bool) {
+	stripe := h.stripes.get(id)
+	stripe.mutex.RLock()
+	defer stripe.mutex.RUnlock()
+	if record, ok := model.Peek(h.store, id); ok {
+		summary := HashSummary{Id: hashIdOf(id, record.PublicId), Status: StatusCompleted, Algorithm: record.Algorithm}
+		if record.IsExpired(time.Now()) {
+			summary.Status = StatusExpired
+		}
+		summary.Created = optionalTime(record.Created)
+		summary.Completed = optionalTime(record.Completed)
+		summary.Expires = optionalTime(record.Expires)
+		return summary, record, true
+	}
+	if pending, ok := stripe.pending[id]; ok {
+		summary := HashSummary{Id: hashIdOf(id, pending.publicId), Status: StatusPending, Algorithm: pending.algorithm}
+		summary.Created = optionalTime(pending.created)
+		return summary, model.HashRecord{}, true
+	}
+	if expired, ok := stripe.expired[id]; ok {
+		return HashSummary{Id: hashIdOf(id, expired.publicId), Status: StatusExpired}, model.HashRecord{}, true
+	}
+	return HashSummary{}, model.HashRecord{}, false
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+/*
+encodeCursor returns an opaque cursor from which a listing continues after id.
+*/
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+/*
+decodeCursor returns the id after which the listing at cursor continues, or 0 if cursor is empty.
+*/
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		var id int
+		id, err = strconv.Atoi(string(decoded))
+		if err == nil {
+			return id, nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("malformed request: invalid cursor: %s", cursor))
+}
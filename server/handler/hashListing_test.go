@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+func listHashes(t *testing.T, h *HashHandler, query string, header string) (HashListing, int) {
+	req, err := http.NewRequest("GET", "http://12.34.56.78:4321/hash"+query, nil)
+	if err != nil {
+		t.Fatalf("Failed to construct GET request")
+	}
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	var listing HashListing
+	if writer.LastStatus == 0 {
+		if err := json.Unmarshal(writer.LastData, &listing); err != nil {
+			t.Fatalf("Failed to parse listing %s: %s", writer.LastData, err)
+		}
+	}
+	return listing, writer.LastStatus
+}
+
+func newListingHandler(t *testing.T) *HashHandler {
+	wg := new(sync.WaitGroup)
+	store := model.NewMemoryStore()
+	h := NewHashHandler(model.NewStats(), store, wg)
+	h.SetDelay(0)
+	h.submitHash("a", "sha256", 0, "")
+	h.submitHash("b", "sha512", 0, "")
+	h.submitHash("c", "sha256", 0, "")
+	wg.Wait()
+	store.Put(4, model.HashRecord{Algorithm: "sha256", Hash: []byte{4}, Expires: time.Now()})
+	h.nextId.Store(4)
+	h.sweepExpired()
+	h.SetDelay(time.Hour)
+	h.getNextHashId("sha256", 0, "")
+	return h
+}
+
+func TestListHashesPages(t *testing.T) {
+	h := newListingHandler(t)
+	var ids []HashId
+	var statuses []string
+	query := "?limit=2"
+	for pages := 0; pages < 5; pages++ {
+		listing, status := listHashes(t, h, query, "")
+		if status != 0 {
+			t.Fatalf("Listing failed with status %d", status)
+		}
+		for _, summary := range listing.Hashes {
+			ids = append(ids, summary.Id)
+			statuses = append(statuses, summary.Status)
+			if summary.Hash != "" {
+				t.Errorf("Listing without admin authorization included hash %s", summary.Hash)
+			}
+		}
+		if listing.Next == "" {
+			break
+		}
+		query = "?limit=2&after=" + listing.Next
+	}
+	expectedIds := []HashId{"1", "2", "3", "4", "5"}
+	expectedStatuses := []string{StatusCompleted, StatusCompleted, StatusCompleted, StatusExpired, StatusPending}
+	if len(ids) != 5 {
+		t.Fatalf("Expected ids %v, got %v", expectedIds, ids)
+	}
+	for i := range ids {
+		if ids[i] != expectedIds[i] || statuses[i] != expectedStatuses[i] {
+			t.Errorf("Expected %s %s, got %s %s", expectedIds[i], expectedStatuses[i], ids[i], statuses[i])
+		}
+	}
+}
+
+func TestListHashesFilters(t *testing.T) {
+	h := newListingHandler(t)
+	listing, _ := listHashes(t, h, "?status=completed&algorithm=sha256", "")
+	if len(listing.Hashes) != 2 || listing.Hashes[0].Id != "1" || listing.Hashes[1].Id != "3" {
+		t.Errorf("Expected completed sha256 hashes 1 and 3, got %v", listing.Hashes)
+	}
+	if listing.Hashes[0].Created == nil || listing.Hashes[0].Completed == nil || listing.Hashes[0].Expires != nil {
+		t.Errorf("Expected creation and completion times only, got %v", listing.Hashes[0])
+	}
+	listing, _ = listHashes(t, h, "?status=pending&algorithm=sha256", "")
+	if len(listing.Hashes) != 1 || listing.Hashes[0].Id != "5" || listing.Hashes[0].Completed != nil {
+		t.Errorf("Expected pending hash 5, got %v", listing.Hashes)
+	}
+	for _, query := range []string{"?limit=0", "?limit=x", "?after=!!", "?status=done"} {
+		if _, status := listHashes(t, h, query, ""); status != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, query, status)
+		}
+	}
+}
+
+func TestListHashesAdmin(t *testing.T) {
+	h := newListingHandler(t)
+	admin := NewAdminHandler(h)
+	admin.SetToken("secret")
+	h.SetAdminAuthorizer(admin.IsAuthorized)
+	listing, _ := listHashes(t, h, "?limit=1&encoding=hex", "Bearer guess")
+	if listing.Hashes[0].Hash != "" {
+		t.Errorf("Listing with a wrong token included hash %s", listing.Hashes[0].Hash)
+	}
+	listing, _ = listHashes(t, h, "?limit=1&encoding=hex", "Bearer secret")
+	if listing.Hashes[0].Hash != "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb" {
+		t.Errorf("Expected admin listing to include the hash, got %v", listing.Hashes[0])
+	}
+}
+
+func TestListHashesHidesPublicIds(t *testing.T) {
+	h := newListingHandler(t)
+	admin := NewAdminHandler(h)
+	admin.SetToken("secret")
+	h.SetAdminAuthorizer(admin.IsAuthorized)
+	h.SetIdMode(IdModeUUID)
+	publicId := h.publicIdOf(h.getNextHashId("sha256", 0, ""))
+	listing, _ := listHashes(t, h, "", "")
+	for _, summary := range listing.Hashes {
+		if summary.Id == HashId(publicId) {
+			t.Errorf("Anonymous listing included public id %s", publicId)
+		}
+	}
+	if len(listing.Hashes) != 5 {
+		t.Errorf("Expected 5 hashes with integer ids, got %d", len(listing.Hashes))
+	}
+	listing, _ = listHashes(t, h, "", "Bearer secret")
+	if len(listing.Hashes) != 6 || listing.Hashes[5].Id != HashId(publicId) {
+		t.Errorf("Expected admin listing to end with public id %s, got %v", publicId, listing.Hashes)
+	}
+}
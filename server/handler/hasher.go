@@ -1,20 +1,144 @@
 package handler
 
 import (
+	"crypto/sha256"
+	"crypto/sha3"
 	"crypto/sha512"
-	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
 )
 
-type Hasher struct {
+const (
+	// DefaultAlgorithm is the algorithm used when neither the server nor the request selects one.
+	DefaultAlgorithm = "sha512"
+)
+
+/*
+A Hasher computes the hash of a string using a single named algorithm.
+*/
+type Hasher interface {
+	Algorithm() string
+	Hash(s string) []byte
+}
+
+/*
+A StreamHasher is a Hasher that can also hash arbitrary binary data as it is read from a stream.
+*/
+type StreamHasher interface {
+	Hasher
+	HashStream(r io.Reader) ([]byte, error)
+}
+
+type digestHasher struct {
+	algorithm string
+	newHash   func() hash.Hash
+}
+
+var hashers = map[string]Hasher{}
+
+/*
+algorithmStrength ranks the built-in algorithms from weakest to strongest, for deciding whether
+a verified hash should be rehashed with the default algorithm. Algorithms that are not ranked,
+such as those registered by other packages, are never compared.
+*/
+var algorithmStrength = map[string]int{
+	"sha256":        1,
+	"sha512-256":    1,
+	"sha3-256":      2,
+	"sha384":        3,
+	"sha512":        4,
+	"sha3-512":      5,
+	"pbkdf2-sha512": 6,
+}
+
+/*
+isStronger reports whether algorithm is ranked above other. It reports false if either is unranked.
+*/
+func isStronger(algorithm string, other string) bool {
+	strength, ok := algorithmStrength[algorithm]
+	otherStrength, otherOk := algorithmStrength[other]
+	return ok && otherOk && strength > otherStrength
 }
 
-func NewHasher() *Hasher {
-	h := new(Hasher)
-	return h
+func init() {
+	RegisterHasher(&digestHasher{"sha256", sha256.New})
+	RegisterHasher(&digestHasher{"sha384", sha512.New384})
+	RegisterHasher(&digestHasher{"sha512", sha512.New})
+	RegisterHasher(&digestHasher{"sha512-256", sha512.New512_256})
+	RegisterHasher(&digestHasher{"sha3-256", func() hash.Hash { return sha3.New256() }})
+	RegisterHasher(&digestHasher{"sha3-512", func() hash.Hash { return sha3.New512() }})
 }
 
-func (h *Hasher) Hash(s string) string {
-	hasher := sha512.New()
+/*
+NewHasher returns the Hasher for DefaultAlgorithm.
+*/
+func NewHasher() Hasher {
+	return hashers[DefaultAlgorithm]
+}
+
+/*
+RegisterHasher makes h available for selection by its algorithm name,
+replacing any Hasher previously registered under the same name.
+*/
+func RegisterHasher(h Hasher) {
+	hashers[h.Algorithm()] = h
+}
+
+/*
+GetHasher returns the Hasher registered for algorithm.
+*/
+func GetHasher(algorithm string) (Hasher, error) {
+	h, ok := hashers[algorithm]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unsupported hash algorithm: %s", algorithm))
+	}
+	return h, nil
+}
+
+/*
+Algorithms returns the sorted names of all registered algorithms.
+*/
+func Algorithms() []string {
+	names := make([]string, 0, len(hashers))
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+hashSize returns the length of the hashes computed by hasher.
+*/
+func hashSize(hasher Hasher) int {
+	if sized, ok := hasher.(interface{ Size() int }); ok {
+		return sized.Size()
+	}
+	return len(hasher.Hash(""))
+}
+
+func (h *digestHasher) Algorithm() string {
+	return h.algorithm
+}
+
+func (h *digestHasher) Hash(s string) []byte {
+	hasher := h.newHash()
 	hasher.Write([]byte(s))
-	return base64.StdEncoding.EncodeToString(hasher.Sum([]byte(nil)))
+	return hasher.Sum([]byte(nil))
+}
+
+func (h *digestHasher) Size() int {
+	return h.newHash().Size()
+}
+
+func (h *digestHasher) HashStream(r io.Reader) ([]byte, error) {
+	hasher := h.newHash()
+	_, err := io.Copy(hasher, r)
+	if err != nil {
+		return nil, err
+	}
+	return hasher.Sum([]byte(nil)), nil
 }
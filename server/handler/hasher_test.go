@@ -1,13 +1,15 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/base64"
 	"testing"
 )
 
 func TestGetExampleHash(t *testing.T) {
 	h := NewHasher()
 	str := "angryMonkey"
-	result0 := h.Hash(str)
+	result0 := base64.StdEncoding.EncodeToString(h.Hash(str))
 	expected := "ZEHhWB65gUlzdVwtDQArEyx+KVLzp/aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A+gf7Q=="
 	if result0 != expected {
 		t.Errorf("Expected (%s) got (%s)", expected, result0)
@@ -19,7 +21,7 @@ func TestGetSameHash(t *testing.T) {
 	str := "angryMonkey"
 	result0 := h.Hash(str)
 	result1 := h.Hash(str)
-	if result0 != result1 {
+	if !bytes.Equal(result0, result1) {
 		t.Errorf("I don't know how hashes work")
 	}
 
@@ -40,3 +42,59 @@ func TestGetOtherHash(t *testing.T) {
 		}
 	}
 }
+
+func TestGetRegisteredHashers(t *testing.T) {
+	for _, algorithm := range Algorithms() {
+		h, err := GetHasher(algorithm)
+		if err != nil {
+			t.Errorf("Failed to get registered hasher %s: %s", algorithm, err)
+			continue
+		}
+		if h.Algorithm() != algorithm {
+			t.Errorf("Expected algorithm %s, got %s", algorithm, h.Algorithm())
+		}
+	}
+}
+
+func TestGetSha256Hash(t *testing.T) {
+	h, err := GetHasher("sha256")
+	if err != nil {
+		t.Fatalf("Failed to get sha256 hasher: %s", err)
+	}
+	result := base64.StdEncoding.EncodeToString(h.Hash("angryMonkey"))
+	expected := "/iKaK4dQuFt0w2h6u20dpZQ7EPaM30pdx/sWN4BXIR8="
+	if result != expected {
+		t.Errorf("Expected (%s) got (%s)", expected, result)
+	}
+}
+
+func TestGetUnknownHasher(t *testing.T) {
+	_, err := GetHasher("rot13")
+	if err == nil {
+		t.Errorf("Expected error for unknown algorithm")
+	}
+}
+
+func TestGetSaltedHash(t *testing.T) {
+	h, err := GetHasher("pbkdf2-sha512")
+	if err != nil {
+		t.Fatalf("Failed to get pbkdf2 hasher: %s", err)
+	}
+	salted, ok := h.(SaltedHasher)
+	if !ok {
+		t.Fatalf("pbkdf2 hasher is not a SaltedHasher")
+	}
+	result0, _ := salted.HashSalted("angryMonkey", []byte("salt0"), 1000)
+	result1, _ := salted.HashSalted("angryMonkey", []byte("salt0"), 1000)
+	result2, _ := salted.HashSalted("angryMonkey", []byte("salt1"), 1000)
+	result3, _ := salted.HashSalted("angryMonkey", []byte("salt0"), 1001)
+	if !bytes.Equal(result0, result1) {
+		t.Errorf("Same salt and iterations produced different hashes")
+	}
+	if bytes.Equal(result0, result2) {
+		t.Errorf("Different salts produced the same hash")
+	}
+	if bytes.Equal(result0, result3) {
+		t.Errorf("Different iteration counts produced the same hash")
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// idStripeCount is the number of stripes among which a HashHandler divides its ids.
+const idStripeCount = 64
+
+/*
+An idStripe holds the handler's bookkeeping for the ids assigned to it, and the lock that
+serializes changes to those ids, both in the bookkeeping and in the store.
+The ids of recently expired hashes are kept along with their public ids, if any.
+Ids in different stripes can be changed concurrently.
+*/
+type idStripe struct {
+	mutex     sync.RWMutex
+	pending   map[int]pendingHash
+	cancelled map[int]bool
+	expired   map[int]expiredHash
+}
+
+/*
+An expiredHash records the public id, if any, of an expired hash, and when it was swept.
+*/
+type expiredHash struct {
+	publicId string
+	swept    time.Time
+}
+
+/*
+idStripes divides ids among a fixed number of idStripes.
+*/
+type idStripes []*idStripe
+
+func newIdStripes(n int) idStripes {
+	stripes := make(idStripes, n)
+	for i := range stripes {
+		stripes[i] = &idStripe{
+			pending:   make(map[int]pendingHash),
+			cancelled: make(map[int]bool),
+			expired:   make(map[int]expiredHash),
+		}
+	}
+	return stripes
+}
+
+/*
+get returns the stripe holding id.
+*/
+func (s idStripes) get(id int) *idStripe {
+	return s[uint(id)%uint(len(s))]
+}
+
+/*
+lockAll locks every stripe, always in the same order, so that no id can change until unlockAll.
+*/
+func (s idStripes) lockAll() {
+	for _, stripe := range s {
+		stripe.mutex.Lock()
+	}
+}
+
+func (s idStripes) unlockAll() {
+	for _, stripe := range s {
+		stripe.mutex.Unlock()
+	}
+}
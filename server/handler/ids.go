@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+const (
+	// IdModeInteger issues sequential integer ids: 1, 2, 3 and so on.
+	IdModeInteger = "integer"
+	// IdModeUUID issues random, time-ordered UUIDv7 ids, which cannot be enumerated.
+	IdModeUUID = "uuid"
+)
+
+/*
+A HashId is a hash id as presented to clients: either an integer id, or the opaque public id
+of a hash created in IdModeUUID. It is marshaled to JSON as a number or a string respectively.
+*/
+type HashId string
+
+func (id HashId) MarshalJSON() ([]byte, error) {
+	if _, err := strconv.Atoi(string(id)); err == nil {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+/*
+isPublic reports whether id is a public id rather than an integer id.
+*/
+func (id HashId) isPublic() bool {
+	_, err := strconv.Atoi(string(id))
+	return err != nil
+}
+
+func (id *HashId) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*id = HashId(s)
+		return nil
+	}
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*id = HashId(strconv.Itoa(n))
+	return nil
+}
+
+// idReserveBlock is the number of ids reserved at a time in a store that is a model.IdTracker.
+const idReserveBlock = 100
+
+/*
+newUUIDv7 returns a new UUID of version 7, as specified by RFC 9562, in canonical lowercase form.
+Its first 48 bits are the Unix time in milliseconds, so that later ids sort after earlier ones,
+and the remaining bits other than the version and variant are random.
+*/
+func newUUIDv7(now time.Time) string {
+	var uuid [16]byte
+	rand.Read(uuid[6:])
+	var millis [8]byte
+	binary.BigEndian.PutUint64(millis[:], uint64(now.UnixMilli()))
+	copy(uuid[:6], millis[2:])
+	uuid[6] = 0x70 | uuid[6]&0x0f
+	uuid[8] = 0x80 | uuid[8]&0x3f
+	return formatUUID(uuid)
+}
+
+func formatUUID(uuid [16]byte) string {
+	s := hex.EncodeToString(uuid[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+/*
+parseUUID reports whether s is a UUID in canonical form, and returns it in lowercase.
+*/
+func parseUUID(s string) (string, bool) {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return "", false
+	}
+	var uuid [16]byte
+	_, err := hex.Decode(uuid[:], []byte(s[:8]+s[9:13]+s[14:18]+s[19:23]+s[24:]))
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(s), true
+}
+
+/*
+SetIdMode selects how the handler identifies new hashes to clients: IdModeInteger, the default,
+or IdModeUUID. Hashes keep the kind of id they were created with when the mode changes.
+*/
+func (h *HashHandler) SetIdMode(mode string) error {
+	if mode != IdModeInteger && mode != IdModeUUID {
+		return errors.New(fmt.Sprintf("unknown id mode: %s", mode))
+	}
+	h.idMode = mode
+	return nil
+}
+
+/*
+resolveId returns the internal id of the hash identified by a client as reqId.
+A public id resolves to the hash it was issued for, and an integer id resolves to itself,
+unless the hash it names has a public id, so that hashes created in IdModeUUID cannot be
+enumerated. An id that resolves to no hash is returned as 0, which is never assigned.
+*/
+func (h *HashHandler) resolveId(reqId string) (int, error) {
+	if publicId, ok := parseUUID(reqId); ok {
+		if id, ok := h.publicIds.Load(publicId); ok {
+			return id.(int), nil
+		}
+		return 0, nil
+	}
+	id, err := strconv.Atoi(reqId)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("malformed request: invalid request id: %s", reqId))
+	}
+	if h.publicIdOf(id) != "" {
+		return 0, nil
+	}
+	return id, nil
+}
+
+/*
+publicIdOf returns the public id of the pending, stored or expired hash id, or "" if it has none.
+*/
+func (h *HashHandler) publicIdOf(id int) string {
+	stripe := h.stripes.get(id)
+	stripe.mutex.RLock()
+	defer stripe.mutex.RUnlock()
+	if pending, ok := stripe.pending[id]; ok {
+		return pending.publicId
+	}
+	if expired, ok := stripe.expired[id]; ok {
+		return expired.publicId
+	}
+	record, _ := model.Peek(h.store, id)
+	return record.PublicId
+}
+
+/*
+externalId returns the id by which clients refer to the hash id.
+*/
+func (h *HashHandler) externalId(id int) HashId {
+	return hashIdOf(id, h.publicIdOf(id))
+}
+
+/*
+hashIdOf returns publicId as a HashId, or id if publicId is empty.
+*/
+func hashIdOf(id int, publicId string) HashId {
+	if publicId != "" {
+		return HashId(publicId)
+	}
+	return HashId(strconv.Itoa(id))
+}
+
+/*
+indexPublicIds adds the public ids of all stored records to the handler's index.
+*/
+func (h *HashHandler) indexPublicIds() {
+	for _, id := range h.store.List() {
+		if record, ok := model.Peek(h.store, id); ok && record.PublicId != "" {
+			h.publicIds.Store(record.PublicId, id)
+		}
+	}
+}
+
+/*
+reserveId ensures that the store has reserved id, if it is a model.IdTracker, by reserving the
+next idReserveBlock ids whenever id is beyond those already reserved. After a restart, ids continue
+from the end of the last reserved block.
+*/
+func (h *HashHandler) reserveId(id int) {
+	if int64(id) <= h.reservedId.Load() {
+		return
+	}
+	tracker, ok := h.store.(model.IdTracker)
+	if !ok {
+		return
+	}
+	h.reserving.Lock()
+	defer h.reserving.Unlock()
+	if int64(id) <= h.reservedId.Load() {
+		return
+	}
+	maxId := id + idReserveBlock - 1
+	if err := tracker.ReserveIds(maxId); err != nil {
+		log.Println(err)
+		return
+	}
+	h.reservedId.Store(int64(maxId))
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+func TestNewUUIDv7(t *testing.T) {
+	now := time.UnixMilli(0x0123456789ab)
+	uuid := newUUIDv7(now)
+	if len(uuid) != 36 || uuid[:13] != "01234567-89ab" || uuid[14] != '7' {
+		t.Errorf("Expected version 7 UUID with timestamp 0123456789ab, got %s", uuid)
+	}
+	if variant := uuid[19]; variant != '8' && variant != '9' && variant != 'a' && variant != 'b' {
+		t.Errorf("Expected RFC 9562 variant, got %s", uuid)
+	}
+	if later := newUUIDv7(now.Add(time.Millisecond)); later <= uuid {
+		t.Errorf("Expected %s to sort after %s", later, uuid)
+	}
+	if newUUIDv7(now) == uuid {
+		t.Errorf("Expected UUIDs in the same millisecond to differ")
+	}
+}
+
+func TestParseUUID(t *testing.T) {
+	if uuid, ok := parseUUID("0190A8C4-1B2C-7D3E-8F40-123456789ABC"); !ok || uuid != "0190a8c4-1b2c-7d3e-8f40-123456789abc" {
+		t.Errorf("Failed to parse uppercase UUID, got %s", uuid)
+	}
+	for _, s := range []string{"", "42", "0190a8c41b2c7d3e8f40123456789abc", "0190a8c4-1b2c-7d3e-8f40-123456789abx"} {
+		if _, ok := parseUUID(s); ok {
+			t.Errorf("Expected %q not to parse as a UUID", s)
+		}
+	}
+}
+
+func TestHashIdMarshal(t *testing.T) {
+	output, _ := json.Marshal([]HashId{"7", "0190a8c4-1b2c-7d3e-8f40-123456789abc"})
+	expected := `[7,"0190a8c4-1b2c-7d3e-8f40-123456789abc"]`
+	if string(output) != expected {
+		t.Errorf("Expected %s got %s", expected, output)
+	}
+}
+
+func TestUUIDIds(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	store := model.NewMemoryStore()
+	h := NewHashHandler(model.NewStats(), store, wg)
+	h.SetDelay(0)
+	h.submitHash("old", "sha256", 0, "")
+	if err := h.SetIdMode("guid"); err == nil {
+		t.Errorf("Expected error setting unknown id mode")
+	}
+	h.SetIdMode(IdModeUUID)
+	req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/hash", bytes.NewBufferString("password=new&algorithm=sha256"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	writer := new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	wg.Wait()
+	publicId := string(writer.LastData)
+	if _, ok := parseUUID(publicId); !ok {
+		t.Fatalf("Expected a UUID, got %s", publicId)
+	}
+
+	get := func(id string) int {
+		req, _ := http.NewRequest("GET", "http://12.34.56.78:4321/hash/"+id, nil)
+		writer := new(MockResponseWriter)
+		h.HandleRequest(writer, req)
+		return writer.LastStatus
+	}
+	if status := get(publicId); status != 0 {
+		t.Errorf("Expected hash for %s, got status %d", publicId, status)
+	}
+	if status := get("2"); status != http.StatusNotFound {
+		t.Errorf("Expected integer id of hash with public id to be unknown, got status %d", status)
+	}
+	if status := get("1"); status != 0 {
+		t.Errorf("Expected hash created in integer mode to keep its id, got status %d", status)
+	}
+
+	restarted := NewHashHandler(model.NewStats(), store, wg)
+	if id, _ := restarted.resolveId(publicId); id != 2 {
+		t.Errorf("Expected public id to resolve to %d after restart, got %d", 2, id)
+	}
+
+	url := fmt.Sprintf("http://12.34.56.78:4321/hash?ids=1,2,%s,%s", publicId, newUUIDv7(time.Now()))
+	req, _ = http.NewRequest("GET", url, nil)
+	writer = new(MockResponseWriter)
+	h.HandleRequest(writer, req)
+	var statuses map[string]HashStatus
+	json.Unmarshal(writer.LastData, &statuses)
+	if statuses["1"].Status != StatusCompleted || statuses["2"].Status != StatusMissing ||
+		statuses[publicId].Status != StatusCompleted || len(statuses) != 4 {
+		t.Errorf("Unexpected bulk statuses %s", writer.LastData)
+	}
+
+	req, _ = http.NewRequest("DELETE", "http://12.34.56.78:4321/hash/"+publicId, nil)
+	h.HandleRequest(new(MockResponseWriter), req)
+	if status := get(publicId); status != http.StatusNotFound || store.Len() != 1 {
+		t.Errorf("Expected deleted hash to be unknown, got status %d", status)
+	}
+}
+
+func TestIdsReservedAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	store, err := model.OpenLogStore(dir, model.LogStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHashHandler(model.NewStats(), store, new(sync.WaitGroup))
+	h.SetDelay(time.Hour)
+	first := h.getNextHashId("", 0, "")
+	h.deleteHash(first)
+	h.getNextHashId("", 0, "")
+	h.Shutdown()
+	store.Close()
+
+	store, err = model.OpenLogStore(dir, model.LogStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	h = NewHashHandler(model.NewStats(), store, new(sync.WaitGroup))
+	if id := h.getNextHashId("", 0, ""); id <= 2 {
+		t.Errorf("Expected ids assigned before the restart not to be reused, got %d", id)
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+A Keyring is a threadsafe set of secret pepper keys, identified by key ID.
+The most recently added key is the active key, which is used to pepper new hashes.
+Older keys are retained so that hashes peppered with them can still be verified.
+*/
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+	mutex  sync.RWMutex
+}
+
+func NewKeyring() *Keyring {
+	k := new(Keyring)
+	k.keys = make(map[string][]byte)
+	return k
+}
+
+/*
+LoadKeyring initializes and returns a new Keyring holding the keys in the file at path.
+See Keyring.Load for the file format.
+*/
+func LoadKeyring(path string) (*Keyring, error) {
+	k := NewKeyring()
+	err := k.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+/*
+Load adds the keys in the file at path to the keyring.
+Each non-blank line of the file that does not begin with '#' holds a key ID and a
+base64-encoded key, separated by whitespace. The last key in the file becomes the active key.
+Keys already in the keyring may be repeated, but may not be changed; this allows
+a new active key to be appended to the file and the file to be loaded again.
+*/
+func (k *Keyring) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return errors.New(fmt.Sprintf("malformed keyring %s:%d: expected key ID and key", path, lineNumber))
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return errors.New(fmt.Sprintf("malformed keyring %s:%d: %s", path, lineNumber, err))
+		}
+		err = k.AddKey(fields[0], key)
+		if err != nil {
+			return errors.New(fmt.Sprintf("malformed keyring %s:%d: %s", path, lineNumber, err))
+		}
+	}
+	return scanner.Err()
+}
+
+/*
+AddKey adds key to the keyring under id, and makes it the active key.
+Adding a different key under an ID already in the keyring is an error.
+Key IDs may not contain the characters '$', ',' or '=', since they appear in PHC strings.
+*/
+func (k *Keyring) AddKey(id string, key []byte) error {
+	if id == "" || len(key) == 0 {
+		return errors.New("empty key ID or key")
+	}
+	if strings.ContainsAny(id, "$,=") {
+		return errors.New(fmt.Sprintf("invalid key ID: %s", id))
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if existing, ok := k.keys[id]; ok && !bytes.Equal(existing, key) {
+		return errors.New(fmt.Sprintf("key ID %s is already in use by a different key", id))
+	}
+	k.keys[id] = key
+	k.active = id
+	return nil
+}
+
+/*
+ActiveKeyId returns the ID of the active key, or "" if the keyring is empty.
+*/
+func (k *Keyring) ActiveKeyId() string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.active
+}
+
+/*
+Pepper returns the HMAC-SHA512 of pwd under the key with the given id.
+*/
+func (k *Keyring) Pepper(id string, pwd string) (string, error) {
+	k.mutex.RLock()
+	key, ok := k.keys[id]
+	k.mutex.RUnlock()
+	if !ok {
+		return "", errors.New(fmt.Sprintf("unknown pepper key ID: %s", id))
+	}
+	mac := hmac.New(sha512.New, key)
+	mac.Write([]byte(pwd))
+	return string(mac.Sum(nil)), nil
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKeyring(t *testing.T, path string, contents string) {
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write keyring: %s", err)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring")
+	writeKeyring(t, path, "# pepper keys\nold MDEyMzQ1Njc4OWFiY2RlZg==\n\nnew ZmVkY2JhOTg3NjU0MzIxMA==\n")
+	k, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("Failed to load keyring: %s", err)
+	}
+	if k.ActiveKeyId() != "new" {
+		t.Errorf("Expected active key %s, got %s", "new", k.ActiveKeyId())
+	}
+	oldPepper, err := k.Pepper("old", "angryMonkey")
+	if err != nil {
+		t.Errorf("Failed to pepper with old key: %s", err)
+	}
+	newPepper, _ := k.Pepper("new", "angryMonkey")
+	if oldPepper == newPepper {
+		t.Errorf("Different keys produced the same pepper")
+	}
+	if _, err := k.Pepper("missing", "angryMonkey"); err == nil {
+		t.Errorf("Expected error for unknown key ID")
+	}
+}
+
+func TestReloadKeyringRotatesKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring")
+	writeKeyring(t, path, "1 MDEyMzQ1Njc4OWFiY2RlZg==\n")
+	k, _ := LoadKeyring(path)
+	writeKeyring(t, path, "1 MDEyMzQ1Njc4OWFiY2RlZg==\n2 ZmVkY2JhOTg3NjU0MzIxMA==\n")
+	if err := k.Load(path); err != nil {
+		t.Fatalf("Failed to reload keyring: %s", err)
+	}
+	if k.ActiveKeyId() != "2" {
+		t.Errorf("Expected active key %s, got %s", "2", k.ActiveKeyId())
+	}
+	writeKeyring(t, path, "1 ZmVkY2JhOTg3NjU0MzIxMA==\n")
+	if err := k.Load(path); err == nil {
+		t.Errorf("Expected error when changing an existing key")
+	}
+}
+
+func TestLoadMalformedKeyring(t *testing.T) {
+	malformed := []string{
+		"onlyanid\n",
+		"1 not-base64!\n",
+		"a$b MDEyMzQ1Njc4OWFiY2RlZg==\n",
+	}
+	for _, contents := range malformed {
+		path := filepath.Join(t.TempDir(), "keyring")
+		writeKeyring(t, path, contents)
+		if _, err := LoadKeyring(path); err == nil {
+			t.Errorf("Expected error loading %q", contents)
+		}
+	}
+}
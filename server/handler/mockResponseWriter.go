@@ -6,11 +6,16 @@ import "net/http"
 A MockResponseWriter is used for unit testing http handler functions.
 */
 type MockResponseWriter struct {
-	LastData []byte
+	LastData    []byte
+	LastStatus  int
+	LastHeaders http.Header
 }
 
 func (m *MockResponseWriter) Header() http.Header {
-	return make(http.Header)
+	if m.LastHeaders == nil {
+		m.LastHeaders = make(http.Header)
+	}
+	return m.LastHeaders
 }
 
 func (m *MockResponseWriter) Write(data []byte) (int, error) {
@@ -18,5 +23,6 @@ func (m *MockResponseWriter) Write(data []byte) (int, error) {
 	return len(data), nil
 }
 
-func (m *MockResponseWriter) WriteHeader(int) {
+func (m *MockResponseWriter) WriteHeader(status int) {
+	m.LastStatus = status
 }
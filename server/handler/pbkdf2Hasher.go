@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha512"
+)
+
+const (
+	// DefaultIterations is the PBKDF2 iteration count used unless the server is configured otherwise.
+	DefaultIterations = 210000
+	// SaltLength is the number of random salt bytes generated for each salted hash.
+	SaltLength = 16
+)
+
+/*
+A SaltedHasher is a Hasher whose result also depends on a per-record salt and an iteration count.
+The handler generates a fresh random salt for every hash it computes with a SaltedHasher,
+and stores the salt and iteration count alongside the hash.
+*/
+type SaltedHasher interface {
+	Hasher
+	HashSalted(s string, salt []byte, iterations int) ([]byte, error)
+}
+
+type pbkdf2Hasher struct {
+	algorithm string
+	keyLength int
+}
+
+func init() {
+	RegisterHasher(&pbkdf2Hasher{"pbkdf2-sha512", sha512.Size})
+}
+
+func (h *pbkdf2Hasher) Algorithm() string {
+	return h.algorithm
+}
+
+/*
+Hash computes an unsalted hash with DefaultIterations. It exists to satisfy the Hasher interface;
+stored records are always computed with HashSalted.
+*/
+func (h *pbkdf2Hasher) Hash(s string) []byte {
+	result, err := h.HashSalted(s, nil, DefaultIterations)
+	if err != nil {
+		return nil
+	}
+	return result
+}
+
+func (h *pbkdf2Hasher) Size() int {
+	return h.keyLength
+}
+
+func (h *pbkdf2Hasher) HashSalted(s string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha512.New, s, salt, iterations, h.keyLength)
+}
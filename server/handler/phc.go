@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+/*
+FormatPHC encodes record as a self-describing PHC string.
+Salted records are formatted as $<algorithm>$i=<iterations>$<salt>$<hash>,
+and unsalted records as $<algorithm>$<hash>.
+Peppered records also carry the pepper key ID as the parameter k=<key ID>.
+Salt and hash use unpadded standard base64, as the PHC string format specifies.
+*/
+func FormatPHC(record model.HashRecord) string {
+	fields := []string{"", record.Algorithm}
+	params := []string{}
+	if record.Salt != nil {
+		params = append(params, "i="+strconv.Itoa(record.Iterations))
+	}
+	if record.KeyId != "" {
+		params = append(params, "k="+record.KeyId)
+	}
+	if len(params) > 0 {
+		fields = append(fields, strings.Join(params, ","))
+	}
+	if record.Salt != nil {
+		fields = append(fields, base64.RawStdEncoding.EncodeToString(record.Salt))
+	}
+	fields = append(fields, base64.RawStdEncoding.EncodeToString(record.Hash))
+	return strings.Join(fields, "$")
+}
+
+/*
+ParsePHC decodes a PHC string produced by FormatPHC into a HashRecord.
+The algorithm must be registered, and salted algorithms must carry an iteration count and a salt.
+*/
+func ParsePHC(s string) (model.HashRecord, error) {
+	record := model.HashRecord{}
+	fields := strings.Split(s, "$")
+	if len(fields) < 3 || fields[0] != "" {
+		return record, errors.New(fmt.Sprintf("malformed PHC string: %s", s))
+	}
+	hasher, err := GetHasher(fields[1])
+	if err != nil {
+		return record, err
+	}
+	record.Algorithm = hasher.Algorithm()
+	fields = fields[2:]
+	params := map[string]string{}
+	if len(fields) > 1 && strings.Contains(fields[0], "=") {
+		params, err = parsePHCParams(fields[0])
+		if err != nil {
+			return record, err
+		}
+		fields = fields[1:]
+	}
+	record.KeyId = params["k"]
+	if _, ok := hasher.(SaltedHasher); ok {
+		if len(fields) != 2 {
+			return record, errors.New(fmt.Sprintf("malformed PHC string: %s", s))
+		}
+		record.Iterations, err = strconv.Atoi(params["i"])
+		if err != nil || record.Iterations < 1 {
+			return record, errors.New(fmt.Sprintf("malformed PHC iteration count: %q", params["i"]))
+		}
+		record.Salt, err = base64.RawStdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return record, errors.New(fmt.Sprintf("malformed PHC salt: %s", err))
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return record, errors.New(fmt.Sprintf("malformed PHC string: %s", s))
+	}
+	record.Hash, err = base64.RawStdEncoding.DecodeString(fields[0])
+	if err != nil {
+		return record, errors.New(fmt.Sprintf("malformed PHC hash: %s", err))
+	}
+	return record, nil
+}
+
+func parsePHCParams(field string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, param := range strings.Split(field, ",") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" || value == "" {
+			return nil, errors.New(fmt.Sprintf("malformed PHC parameter: %s", param))
+		}
+		params[name] = value
+	}
+	return params, nil
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+func mustDecodeBase64(s string) []byte {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func TestFormatParseSaltedPHC(t *testing.T) {
+	record := model.HashRecord{
+		Algorithm:  "pbkdf2-sha512",
+		Hash:       mustDecodeBase64("ZEHhWB65gUlzdVwtDQArEyx+KVLzp/aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A+gf7Q=="),
+		Salt:       []byte("0123456789abcdef"),
+		Iterations: 210000,
+	}
+	phc := FormatPHC(record)
+	expected := "$pbkdf2-sha512$i=210000$MDEyMzQ1Njc4OWFiY2RlZg$ZEHhWB65gUlzdVwtDQArEyx+KVLzp/aTaRaPlBzYRIFj6vjFdqEb0Q5B8zVKCZ0vKbZPZklJz0Fd7su2A+gf7Q"
+	if phc != expected {
+		t.Errorf("Expected %s got %s", expected, phc)
+	}
+	parsed, err := ParsePHC(phc)
+	if err != nil {
+		t.Fatalf("Failed to parse PHC string: %s", err)
+	}
+	if parsed.Algorithm != record.Algorithm || !bytes.Equal(parsed.Hash, record.Hash) ||
+		string(parsed.Salt) != string(record.Salt) || parsed.Iterations != record.Iterations {
+		t.Errorf("Expected %v got %v", record, parsed)
+	}
+}
+
+func TestFormatParseDigestPHC(t *testing.T) {
+	record := model.HashRecord{Algorithm: "sha256", Hash: mustDecodeBase64("/iKaK4dQuFt0w2h6u20dpZQ7EPaM30pdx/sWN4BXIR8=")}
+	phc := FormatPHC(record)
+	expected := "$sha256$/iKaK4dQuFt0w2h6u20dpZQ7EPaM30pdx/sWN4BXIR8"
+	if phc != expected {
+		t.Errorf("Expected %s got %s", expected, phc)
+	}
+	parsed, err := ParsePHC(phc)
+	if err != nil || !bytes.Equal(parsed.Hash, record.Hash) {
+		t.Errorf("Expected %v got %v (%v)", record, parsed, err)
+	}
+}
+
+func TestParseMalformedPHC(t *testing.T) {
+	malformed := []string{
+		"",
+		"sha256$abc",
+		"$rot13$abc",
+		"$sha256$i=1$abc$abc",
+		"$pbkdf2-sha512$abc",
+		"$pbkdf2-sha512$i=0$c2FsdA$abc",
+		"$pbkdf2-sha512$x=1$c2FsdA$abc",
+		"$sha256$not base64!",
+	}
+	for _, s := range malformed {
+		if _, err := ParsePHC(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
+
+func TestFormatParsePepperedPHC(t *testing.T) {
+	record := model.HashRecord{
+		Algorithm:  "pbkdf2-sha512",
+		Hash:       mustDecodeBase64("/iKaK4dQuFt0w2h6u20dpZQ7EPaM30pdx/sWN4BXIR8="),
+		Salt:       []byte("0123456789abcdef"),
+		Iterations: 1000,
+		KeyId:      "2026-10",
+	}
+	phc := FormatPHC(record)
+	expected := "$pbkdf2-sha512$i=1000,k=2026-10$MDEyMzQ1Njc4OWFiY2RlZg$/iKaK4dQuFt0w2h6u20dpZQ7EPaM30pdx/sWN4BXIR8"
+	if phc != expected {
+		t.Errorf("Expected %s got %s", expected, phc)
+	}
+	parsed, err := ParsePHC(phc)
+	if err != nil || parsed.KeyId != record.KeyId || parsed.Iterations != record.Iterations {
+		t.Errorf("Expected %v got %v (%v)", record, parsed, err)
+	}
+	digest, err := ParsePHC("$sha256$k=1$/iKaK4dQuFt0w2h6u20dpZQ7EPaM30pdx/sWN4BXIR8")
+	if err != nil || digest.KeyId != "1" {
+		t.Errorf("Expected key ID 1, got %v (%v)", digest, err)
+	}
+}
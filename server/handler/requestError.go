@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+)
+
+/*
+A requestError is an error that should be reported to the client with a specific http status,
+rather than the default 404 response.
+*/
+type requestError struct {
+	status int
+	err    error
+}
+
+func newRequestError(status int, err error) error {
+	return &requestError{status, err}
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+/*
+writeError responds to a failed request with the status carried by err, or 404 if it has none.
+*/
+func writeError(w http.ResponseWriter, request *http.Request, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		http.Error(w, http.StatusText(reqErr.status), reqErr.status)
+		return
+	}
+	http.NotFound(w, request)
+}
@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+const (
+	// DefaultPingInterval is how often an idle WebSocket client is pinged. A client that sends
+	// nothing, not even a pong, for twice this long is disconnected.
+	DefaultPingInterval = 30 * time.Second
+	// DefaultMaxSocketMessage is the default limit on the length of one message from a WebSocket client.
+	DefaultMaxSocketMessage = 64 * 1024
+	// socketCloseTimeout is how long the server waits for a client to answer its close frame.
+	socketCloseTimeout = 5 * time.Second
+
+	SocketAccepted  = "accepted"
+	SocketCompleted = "completed"
+	SocketError     = "error"
+)
+
+/*
+A SocketHandler handles WebSocket connections to the '/ws' endpoint, over which a client may
+submit any number of passwords for hashing and receive the results without further requests.
+
+Each text message from the client is a JSON SocketRequest, validated and submitted exactly as
+a POST request to '/hash' would be. The server answers each one with a SocketMessage of type
+"accepted" holding the assigned id, or of type "error". Once the hash is stored, the server sends
+a message of type "completed" with the hash, formatted as selected by the query parameters of
+the handshake request (see HashHandler). If the hash is never stored, because it was deleted
+or could not be computed, the server sends a message of type "error" for its id instead.
+The "ref" of a request, if any, is repeated in every message about it.
+
+The server pings idle clients, and disconnects clients that stop answering. When the handler is
+shut down, it stops accepting requests, sends the results of those already accepted, and then
+closes each connection with status 1001 (going away).
+*/
+type SocketHandler struct {
+	hashHandler    *HashHandler
+	stats          *model.Stats
+	pingInterval   time.Duration
+	maxMessageSize int
+	run            atomic.Value
+	stopped        chan struct{}
+	stopOnce       sync.Once
+}
+
+/*
+A SocketRequest is used for unmarshaling a request to hash a password from a WebSocket client.
+Ref is an optional client-chosen reference, which is repeated in the server's messages about the request.
+*/
+type SocketRequest struct {
+	Ref       string `json:"ref,omitempty"`
+	Password  string `json:"password"`
+	Algorithm string `json:"algorithm,omitempty"`
+	TTL       string `json:"ttl,omitempty"`
+}
+
+/*
+A SocketMessage is used for marshaling a message to a WebSocket client to JSON.
+*/
+type SocketMessage struct {
+	Type      string `json:"type"`
+	Ref       string `json:"ref,omitempty"`
+	Id        HashId `json:"id,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+/*
+NewSocketHandler initializes and returns a new SocketHandler.
+Hashes are submitted to hashHandler, and request statistics are logged to stats.
+*/
+func NewSocketHandler(hashHandler *HashHandler, stats *model.Stats) *SocketHandler {
+	s := new(SocketHandler)
+	s.hashHandler = hashHandler
+	s.stats = stats
+	s.pingInterval = DefaultPingInterval
+	s.maxMessageSize = DefaultMaxSocketMessage
+	s.stopped = make(chan struct{})
+	s.run.Store(true)
+	return s
+}
+
+/*
+HandleRequest is an http request handler intended for use with http.ServeMux.
+It returns once the connection has been closed.
+*/
+func (s *SocketHandler) HandleRequest(w http.ResponseWriter, request *http.Request) {
+	if s.run.Load().(bool) {
+		var err error = nil
+		if request.Method == "GET" {
+			err = s.handleGet(w, request)
+		} else {
+			err = errors.New(fmt.Sprintf("unsupported request type: %s", request.Method))
+		}
+		if err != nil {
+			log.Println(err)
+			writeError(w, request, err)
+		}
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+/*
+Shutdown disables further handling of requests by this handler, and closes open connections
+once the hashes they have requested are delivered.
+*/
+func (s *SocketHandler) Shutdown() {
+	s.run.Store(false)
+	s.stopOnce.Do(func() {
+		close(s.stopped)
+	})
+}
+
+/*
+A socketSession counts the hashes accepted on one connection whose results have not been sent.
+Once it is draining, it accepts no more.
+*/
+type socketSession struct {
+	conn        *wsConn
+	request     *http.Request
+	closed      chan struct{}
+	mutex       sync.Mutex
+	outstanding int
+	draining    bool
+	drained     chan struct{}
+}
+
+func (s *SocketHandler) handleGet(w http.ResponseWriter, request *http.Request) error {
+	conn, err := upgradeWebSocket(w, request, s.maxMessageSize)
+	if err != nil {
+		return err
+	}
+	defer conn.close()
+	conn.readTimeout = 2 * s.pingInterval
+	session := &socketSession{conn: conn, request: request, closed: make(chan struct{}), drained: make(chan struct{})}
+	go s.keepAlive(session)
+
+	var workers sync.WaitGroup
+	for {
+		opcode, payload, err := conn.readMessage()
+		var closeErr *wsCloseError
+		if errors.As(err, &closeErr) {
+			log.Println(err)
+			conn.writeClose(closeErr.code, closeErr.reason)
+			break
+		} else if err != nil {
+			break
+		}
+		if opcode == opClose {
+			conn.writeClose(closeNormal, "")
+			break
+		}
+		if opcode == opBinary {
+			conn.writeClose(closeUnsupportedData, "binary messages are not supported")
+			break
+		}
+		s.handleMessage(session, payload, &workers)
+	}
+	close(session.closed)
+	workers.Wait()
+	return nil
+}
+
+/*
+handleMessage submits the hash requested by a message from the client, and answers it.
+If the hash is accepted, a worker is added to workers to send its result.
+*/
+func (s *SocketHandler) handleMessage(session *socketSession, payload []byte, workers *sync.WaitGroup) {
+	startTime := time.Now()
+	var socketRequest SocketRequest
+	if err := json.Unmarshal(payload, &socketRequest); err != nil {
+		session.send(SocketMessage{Type: SocketError, Error: fmt.Sprintf("malformed request: %v", err)})
+		return
+	}
+	ref := socketRequest.Ref
+	var ttl time.Duration
+	if socketRequest.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(socketRequest.TTL)
+		if err != nil || ttl <= 0 {
+			session.send(SocketMessage{Type: SocketError, Ref: ref,
+				Error: fmt.Sprintf("malformed request: invalid 'ttl' field: %s", socketRequest.TTL)})
+			return
+		}
+	}
+	if !s.run.Load().(bool) || !session.begin() {
+		session.send(SocketMessage{Type: SocketError, Ref: ref, Error: "server is shutting down"})
+		return
+	}
+	id, err := s.hashHandler.submitHash(socketRequest.Password, socketRequest.Algorithm, ttl, "")
+	if err != nil {
+		session.end()
+		session.send(SocketMessage{Type: SocketError, Ref: ref, Error: err.Error()})
+		return
+	}
+	hashId := s.hashHandler.externalId(id)
+	session.send(SocketMessage{Type: SocketAccepted, Ref: ref, Id: hashId})
+	s.stats.AddRequest(time.Now().Sub(startTime))
+	workers.Add(1)
+	go func() {
+		s.sendResult(session, id, hashId, ref)
+		workers.Done()
+	}()
+}
+
+/*
+sendResult waits until hash id, known to the client as hashId, is no longer pending, and sends
+its result to the client, unless the connection closes first.
+*/
+func (s *SocketHandler) sendResult(session *socketSession, id int, hashId HashId, ref string) {
+	defer session.end()
+	if pending, ok := s.hashHandler.getPending(id); ok {
+		select {
+		case <-pending.done:
+		case <-session.closed:
+			return
+		}
+	}
+	message := SocketMessage{Type: SocketCompleted, Ref: ref, Id: hashId}
+	record, err := s.hashHandler.lookupRecord(id)
+	if err == nil {
+		message.Algorithm = record.Algorithm
+		message.Hash, err = formatHash(session.request, record)
+	}
+	if err != nil {
+		message = SocketMessage{Type: SocketError, Ref: ref, Id: hashId, Error: err.Error()}
+	}
+	session.send(message)
+}
+
+/*
+keepAlive pings the client every pingInterval until the connection closes. When the handler is
+shut down, it waits for the session to drain, then closes the connection, forcibly if the client
+does not answer the close frame in time.
+*/
+func (s *SocketHandler) keepAlive(session *socketSession) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			session.conn.writeFrame(opPing, nil)
+		case <-session.closed:
+			return
+		case <-s.stopped:
+			select {
+			case <-session.drain():
+			case <-session.closed:
+				return
+			}
+			session.conn.writeClose(closeGoingAway, "server shutting down")
+			timer := time.NewTimer(socketCloseTimeout)
+			defer timer.Stop()
+			select {
+			case <-session.closed:
+			case <-timer.C:
+				session.conn.close()
+			}
+			return
+		}
+	}
+}
+
+/*
+send marshals message and sends it to the client. Failures are ignored, since they mean the
+connection is closing.
+*/
+func (session *socketSession) send(message SocketMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	session.conn.writeFrame(opText, data)
+}
+
+/*
+begin counts a newly accepted hash, and reports false if the session is draining.
+*/
+func (session *socketSession) begin() bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.draining {
+		return false
+	}
+	session.outstanding++
+	return true
+}
+
+/*
+end counts the result of a hash as sent.
+*/
+func (session *socketSession) end() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.outstanding--
+	if session.draining && session.outstanding == 0 {
+		close(session.drained)
+	}
+}
+
+/*
+drain stops the session accepting hashes, and returns a channel closed once every accepted hash
+has been sent. It must be called only once.
+*/
+func (session *socketSession) drain() <-chan struct{} {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.draining = true
+	if session.outstanding == 0 {
+		close(session.drained)
+	}
+	return session.drained
+}
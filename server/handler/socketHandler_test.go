@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+/*
+A socketClient is a minimal WebSocket client for testing a SocketHandler.
+*/
+type socketClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialSocket(t *testing.T, server *httptest.Server, path string) *socketClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols ||
+		response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected handshake response %v", response)
+	}
+	return &socketClient{t, conn, reader}
+}
+
+func (c *socketClient) send(opcode int, payload []byte) {
+	c.conn.Write(clientFrame(true, opcode, payload))
+}
+
+func (c *socketClient) sendRequest(request SocketRequest) {
+	data, _ := json.Marshal(request)
+	c.send(opText, data)
+}
+
+/*
+readFrame returns the opcode and payload of the next frame from the server.
+*/
+func (c *socketClient) readFrame() (int, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var extended [2]byte
+		io.ReadFull(c.reader, extended[:])
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return int(header[0] & 0x0f), payload
+}
+
+func (c *socketClient) readMessage() SocketMessage {
+	opcode, payload := c.readFrame()
+	if opcode != opText {
+		c.t.Fatalf("Expected text message, got opcode %d: %q", opcode, payload)
+	}
+	var message SocketMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		c.t.Fatal(err)
+	}
+	return message
+}
+
+func newSocketTest(delay time.Duration) (*HashHandler, *SocketHandler, *httptest.Server) {
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetDelay(delay)
+	s := NewSocketHandler(h, model.NewStats())
+	return h, s, httptest.NewServer(http.HandlerFunc(s.HandleRequest))
+}
+
+func TestSocketSubmitAndComplete(t *testing.T) {
+	h, _, server := newSocketTest(10 * time.Millisecond)
+	defer server.Close()
+	client := dialSocket(t, server, "/ws?encoding=hex")
+
+	client.sendRequest(SocketRequest{Ref: "a", Password: "angryMonkey", Algorithm: "sha512"})
+	client.sendRequest(SocketRequest{Ref: "b", Password: ""})
+	accepted := client.readMessage()
+	if accepted.Type != SocketAccepted || accepted.Ref != "a" || accepted.Id != "1" {
+		t.Errorf("Unexpected acceptance %+v", accepted)
+	}
+	rejected := client.readMessage()
+	if rejected.Type != SocketError || rejected.Ref != "b" || rejected.Error == "" {
+		t.Errorf("Unexpected rejection %+v", rejected)
+	}
+	completed := client.readMessage()
+	expected, _ := Encode("hex", h.getHash(1))
+	if completed.Type != SocketCompleted || completed.Ref != "a" || completed.Id != "1" ||
+		completed.Algorithm != "sha512" || completed.Hash != expected {
+		t.Errorf("Unexpected completion %+v", completed)
+	}
+
+	client.send(opPing, []byte("x"))
+	if opcode, payload := client.readFrame(); opcode != opPong || string(payload) != "x" {
+		t.Errorf("Expected pong, got %d %q", opcode, payload)
+	}
+	client.send(opClose, []byte{0x03, 0xe8})
+	if opcode, payload := client.readFrame(); opcode != opClose || binary.BigEndian.Uint16(payload) != closeNormal {
+		t.Errorf("Expected close reply, got %d %v", opcode, payload)
+	}
+}
+
+func TestSocketDeletedHash(t *testing.T) {
+	h, _, server := newSocketTest(time.Hour)
+	defer server.Close()
+	client := dialSocket(t, server, "/ws")
+	client.sendRequest(SocketRequest{Password: "angryMonkey"})
+	if accepted := client.readMessage(); accepted.Type != SocketAccepted {
+		t.Fatalf("Unexpected message %+v", accepted)
+	}
+	h.deleteHash(1)
+	if failed := client.readMessage(); failed.Type != SocketError || failed.Id != "1" {
+		t.Errorf("Expected error for deleted hash, got %+v", failed)
+	}
+}
+
+func TestSocketPing(t *testing.T) {
+	_, s, server := newSocketTest(time.Millisecond)
+	defer server.Close()
+	s.pingInterval = 10 * time.Millisecond
+	client := dialSocket(t, server, "/ws")
+	if opcode, _ := client.readFrame(); opcode != opPing {
+		t.Errorf("Expected ping, got opcode %d", opcode)
+	}
+}
+
+func TestSocketShutdown(t *testing.T) {
+	_, s, server := newSocketTest(50 * time.Millisecond)
+	defer server.Close()
+	client := dialSocket(t, server, "/ws")
+	client.sendRequest(SocketRequest{Password: "angryMonkey"})
+	if accepted := client.readMessage(); accepted.Type != SocketAccepted {
+		t.Fatalf("Unexpected message %+v", accepted)
+	}
+	s.Shutdown()
+	client.sendRequest(SocketRequest{Password: "late"})
+	if refused := client.readMessage(); refused.Type != SocketError {
+		t.Errorf("Expected request during shutdown to be refused, got %+v", refused)
+	}
+	if completed := client.readMessage(); completed.Type != SocketCompleted {
+		t.Errorf("Expected accepted hash to be delivered before close, got %+v", completed)
+	}
+	opcode, payload := client.readFrame()
+	if opcode != opClose || binary.BigEndian.Uint16(payload) != closeGoingAway {
+		t.Errorf("Expected close with status %d, got %d %v", closeGoingAway, opcode, payload)
+	}
+	client.send(opClose, []byte{0x03, 0xe9})
+	if _, err := client.reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected connection to be closed, got %v", err)
+	}
+}
+
+func TestSocketHandshakeErrors(t *testing.T) {
+	_, s, _ := newSocketTest(time.Millisecond)
+	request, _ := http.NewRequest("GET", "/ws", nil)
+	w := new(MockResponseWriter)
+	s.HandleRequest(w, request)
+	if w.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d without upgrade headers, got %d", http.StatusBadRequest, w.LastStatus)
+	}
+	request.Header.Set("Connection", "keep-alive, Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Version", "8")
+	w = new(MockResponseWriter)
+	s.HandleRequest(w, request)
+	if w.LastStatus != http.StatusUpgradeRequired || w.Header().Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("Expected status %d for old version, got %d", http.StatusUpgradeRequired, w.LastStatus)
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+// MaxPHCIterationFactor limits the iteration count of a PHC string given to '/verify' to this
+// multiple of the server's iteration count, so that one request cannot tie up the server.
+const MaxPHCIterationFactor = 4
+
+/*
+A VerifyHandler handles POST requests to the '/verify' endpoint.
+A request with an "id" field naming a stored hash and a non-empty "password" field is answered
+with a JSON object whose "match" member reports whether the password produces the stored hash,
+and whose "rehashed" member reports whether the stored hash was upgraded to the current algorithm
+and iteration count as a side effect of the successful verification.
+Instead of an "id", a request may supply a "hash" field holding a PHC string (see ParsePHC),
+in which case the password is verified against that hash rather than a stored one.
+A PHC string with more than MaxPHCIterationFactor times the server's iteration count is refused.
+*/
+type VerifyHandler struct {
+	hashHandler *HashHandler
+	stats       *model.Stats
+	run         atomic.Value
+}
+
+/*
+A VerifyResult is used for marshaling verification output to JSON.
+*/
+type VerifyResult struct {
+	Match    bool `json:"match"`
+	Rehashed bool `json:"rehashed"`
+}
+
+/*
+NewVerifyHandler initializes and returns a new VerifyHandler.
+Hashes are looked up in hashHandler, and each completed verification is counted in stats.
+*/
+func NewVerifyHandler(hashHandler *HashHandler, stats *model.Stats) *VerifyHandler {
+	v := new(VerifyHandler)
+	v.hashHandler = hashHandler
+	v.stats = stats
+	v.run.Store(true)
+	return v
+}
+
+/*
+HandleRequest is an http request handler intended for use with http.ServeMux.
+*/
+func (v *VerifyHandler) HandleRequest(w http.ResponseWriter, request *http.Request) {
+	if v.run.Load().(bool) {
+		var err error = nil
+		if request.Method == "POST" {
+			err = v.handlePost(w, request)
+		} else {
+			err = errors.New(fmt.Sprintf("unsupported request type: %s", request.Method))
+		}
+		if err != nil {
+			log.Println(err)
+			writeError(w, request, err)
+		}
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+/*
+Shutdown disables further handling of requests by this handler.
+*/
+func (v *VerifyHandler) Shutdown() {
+	v.run.Store(false)
+}
+
+func (v *VerifyHandler) handlePost(w http.ResponseWriter, request *http.Request) error {
+	request.ParseForm()
+	password := request.PostForm.Get("password")
+	if password == "" {
+		return newRequestError(http.StatusBadRequest, errors.New("malformed request: empty 'password' field"))
+	}
+	result, err := v.verify(request.PostForm, password)
+	if err != nil {
+		return err
+	}
+	v.stats.AddVerification()
+	output, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+	return nil
+}
+
+func (v *VerifyHandler) verify(form url.Values, password string) (VerifyResult, error) {
+	if phc := form.Get("hash"); phc != "" {
+		record, err := ParsePHC(phc)
+		if err != nil {
+			return VerifyResult{}, newRequestError(http.StatusBadRequest, err)
+		}
+		if maxIterations := v.hashHandler.iterations * MaxPHCIterationFactor; record.Iterations > maxIterations {
+			return VerifyResult{}, newRequestError(http.StatusBadRequest,
+				errors.New(fmt.Sprintf("PHC iteration count %d exceeds the limit of %d", record.Iterations, maxIterations)))
+		}
+		match, err := v.hashHandler.verifyRecord(password, record)
+		return VerifyResult{Match: match}, err
+	}
+	id, err := v.hashHandler.resolveId(form.Get("id"))
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	return v.hashHandler.verify(id, password)
+}
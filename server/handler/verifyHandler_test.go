@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+func newVerifyRequest(t *testing.T, id string, pwd string) *http.Request {
+	form := url.Values{"id": {id}, "password": {pwd}}
+	req, err := http.NewRequest("POST", "http://12.34.56.78:4321/verify", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("Failed to construct POST request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestVerifyMatch(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetIterations(1000)
+	h.SetDefaultAlgorithm("pbkdf2-sha512")
+	h.processHash(1, "angryMonkey", h.hasher)
+	v := NewVerifyHandler(h, stats)
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	expected := "{\"match\":true,\"rehashed\":false}"
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+	if stats.GetStats().Verifications != 1 {
+		t.Errorf("Expected verification to be counted")
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.processHash(1, "angryMonkey", h.hasher)
+	v := NewVerifyHandler(h, stats)
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "happyMonkey"))
+	expected := "{\"match\":false,\"rehashed\":false}"
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+}
+
+func TestVerifyUnknownId(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	v := NewVerifyHandler(h, stats)
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "7", "angryMonkey"))
+	if stats.GetStats().Verifications != 0 {
+		t.Errorf("Failed lookup should not be counted as a verification")
+	}
+	if writer.LastStatus != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, writer.LastStatus)
+	}
+}
+
+func TestVerifyErrorStatus(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.processHash(1, "angryMonkey", h.hasher)
+	record := h.getRecord(1)
+	record.Expires = time.Now().Add(-time.Second)
+	h.store.Put(1, record)
+	v := NewVerifyHandler(h, stats)
+
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", ""))
+	if writer.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d for empty password, got %d", http.StatusBadRequest, writer.LastStatus)
+	}
+	writer = new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	if writer.LastStatus != http.StatusGone {
+		t.Errorf("Expected status %d for expired hash, got %d", http.StatusGone, writer.LastStatus)
+	}
+	form := url.Values{"hash": {"$sha512$!!"}, "password": {"angryMonkey"}}
+	req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/verify", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	writer = new(MockResponseWriter)
+	v.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d for malformed PHC string, got %d", http.StatusBadRequest, writer.LastStatus)
+	}
+}
+
+func TestVerifyPHC(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	v := NewVerifyHandler(h, stats)
+	hasher, _ := GetHasher("pbkdf2-sha512")
+	h.SetIterations(1000)
+	record, _ := h.computeRecord("angryMonkey", hasher)
+	phc := FormatPHC(record)
+	form := url.Values{"hash": {phc}, "password": {"angryMonkey"}}
+	req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/verify", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, req)
+	expected := "{\"match\":true,\"rehashed\":false}"
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+}
+
+func TestVerifyPHCIterationLimit(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetIterations(1000)
+	v := NewVerifyHandler(h, stats)
+	phc := "$pbkdf2-sha512$i=2000000000$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA"
+	form := url.Values{"hash": {phc}, "password": {"angryMonkey"}}
+	req, _ := http.NewRequest("POST", "http://12.34.56.78:4321/verify", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, req)
+	if writer.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, writer.LastStatus)
+	}
+}
+
+func TestVerifyRehashesOutdatedRecord(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.processHash(1, "angryMonkey", h.hasher)
+	h.SetIterations(1000)
+	h.SetDefaultAlgorithm("pbkdf2-sha512")
+	v := NewVerifyHandler(h, stats)
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	expected := "{\"match\":true,\"rehashed\":true}"
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+	record := h.getRecord(1)
+	if record.Algorithm != "pbkdf2-sha512" || record.Iterations != 1000 {
+		t.Errorf("Expected record to be rehashed, got %v", record)
+	}
+
+	h.SetIterations(2000)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	expected = "{\"match\":true,\"rehashed\":false}"
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+}
+
+func TestVerifyRehashesToStrongerDigest(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	sha256Hasher, _ := GetHasher("sha256")
+	h.processHash(1, "angryMonkey", sha256Hasher)
+	h.SetDefaultAlgorithm("sha3-512")
+	v := NewVerifyHandler(h, stats)
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	if record := h.getRecord(1); record.Algorithm != "sha3-512" {
+		t.Errorf("Expected record to be rehashed with sha3-512, got %v", record)
+	}
+
+	h.SetDefaultAlgorithm("sha256")
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	expected := "{\"match\":true,\"rehashed\":false}"
+	if string(writer.LastData) != expected || h.getRecord(1).Algorithm != "sha3-512" {
+		t.Errorf("Expected no rehash to a weaker algorithm, got %s %v", writer.LastData, h.getRecord(1))
+	}
+}
+
+func TestVerifyMismatchDoesNotRehash(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.processHash(1, "angryMonkey", h.hasher)
+	h.SetDefaultAlgorithm("sha256")
+	v := NewVerifyHandler(h, stats)
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "happyMonkey"))
+	if h.getRecord(1).Algorithm != DefaultAlgorithm {
+		t.Errorf("Record was rehashed after a failed verification")
+	}
+}
+
+func TestVerifyDoesNotDowngradeSaltedRecord(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	h.SetIterations(1000)
+	salted, _ := GetHasher("pbkdf2-sha512")
+	h.processHash(1, "angryMonkey", salted)
+	before := h.getRecord(1)
+	v := NewVerifyHandler(h, stats)
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	expected := "{\"match\":true,\"rehashed\":false}"
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+	if after := h.getRecord(1); after.Algorithm != "pbkdf2-sha512" || !bytes.Equal(after.Salt, before.Salt) {
+		t.Errorf("Salted record was replaced by %v", after)
+	}
+
+	keyring := NewKeyring()
+	keyring.AddKey("1", []byte("0123456789abcdef"))
+	h.SetKeyring(keyring)
+	h.SetIterations(500)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	expected = "{\"match\":true,\"rehashed\":true}"
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+	after := h.getRecord(1)
+	if after.Algorithm != "pbkdf2-sha512" || after.Salt == nil || after.Iterations != 1000 || after.KeyId != "1" {
+		t.Errorf("Expected record to be repeppered without weakening, got %v", after)
+	}
+}
+
+func TestVerifyPepperedAfterKeyRotation(t *testing.T) {
+	stats := model.NewStats()
+	h := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	keyring := NewKeyring()
+	keyring.AddKey("1", []byte("0123456789abcdef"))
+	h.SetKeyring(keyring)
+	h.processHash(1, "angryMonkey", h.hasher)
+	if h.getRecord(1).KeyId != "1" {
+		t.Errorf("Expected record to be tagged with key ID 1, got %v", h.getRecord(1))
+	}
+	unpeppered := NewHashHandler(stats, model.NewMemoryStore(), new(sync.WaitGroup))
+	unpeppered.processHash(1, "angryMonkey", unpeppered.hasher)
+	if bytes.Equal(h.getHash(1), unpeppered.getHash(1)) {
+		t.Errorf("Peppered hash matches unpeppered hash")
+	}
+
+	keyring.AddKey("2", []byte("fedcba9876543210"))
+	v := NewVerifyHandler(h, stats)
+	writer := new(MockResponseWriter)
+	v.HandleRequest(writer, newVerifyRequest(t, "1", "angryMonkey"))
+	expected := "{\"match\":true,\"rehashed\":true}"
+	if string(writer.LastData) != expected {
+		t.Errorf("Expected %s got %s", expected, writer.LastData)
+	}
+	if h.getRecord(1).KeyId != "2" {
+		t.Errorf("Expected record to be rehashed under key ID 2, got %v", h.getRecord(1))
+	}
+}
@@ -0,0 +1,348 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+const (
+	// DefaultWebhookAttempts is the number of times a webhook is sent before it is given up.
+	DefaultWebhookAttempts = 5
+	// DefaultWebhookBackoff is the wait before the first retry of a webhook; each later retry waits twice as long.
+	DefaultWebhookBackoff = time.Second
+	// DefaultWebhookLogSize is the number of recent webhook deliveries whose attempts are kept.
+	DefaultWebhookLogSize = 1000
+	// webhookTimeout bounds how long one attempt to send a webhook may take.
+	webhookTimeout = 10 * time.Second
+
+	// WebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the timestamp, a ".", and the body.
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	// WebhookTimestampHeader carries the Unix time at which a webhook was sent.
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+/*
+A WebhookPayload is used for marshaling the notification that a hash has been stored to JSON.
+The hash is encoded in DefaultEncoding.
+*/
+type WebhookPayload struct {
+	Event     string    `json:"event"`
+	Id        HashId    `json:"id"`
+	Algorithm string    `json:"algorithm"`
+	Hash      string    `json:"hash"`
+	Completed time.Time `json:"completed"`
+}
+
+/*
+A WebhookAttempt records one attempt to send a webhook: the response status, if there was a
+response, or else the error.
+*/
+type WebhookAttempt struct {
+	Time   time.Time `json:"time"`
+	Status int       `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+/*
+A WebhookDelivery records the attempts to send the webhook for one hash, and whether one succeeded.
+The URL is omitted when the delivery is reported to a request without admin authorization.
+*/
+type WebhookDelivery struct {
+	URL       string           `json:"url,omitempty"`
+	Delivered bool             `json:"delivered"`
+	Attempts  []WebhookAttempt `json:"attempts"`
+}
+
+/*
+A webhookSender sends signed webhooks, retrying failed attempts with exponential backoff,
+and keeps a record of the most recent deliveries by hash id.
+
+Since callback URLs are chosen by clients, the sender could otherwise be used to reach services
+that only the server can reach. Unless allowPrivate is set, it refuses to connect to any address
+that is not publicly routable, checking each address as it is dialed so that a host name cannot
+resolve to a private address after the URL is accepted. Redirects are never followed; a redirect
+response counts as a failed attempt.
+*/
+type webhookSender struct {
+	client       *http.Client
+	secret       []byte
+	allowPrivate bool
+	attempts     int
+	backoff      time.Duration
+	mutex        sync.Mutex
+	deliveries   map[int]*WebhookDelivery
+	order        []int
+	logSize      int
+}
+
+func newWebhookSender() *webhookSender {
+	s := new(webhookSender)
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: s.checkAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	s.client = &http.Client{
+		Transport: transport,
+		Timeout:   webhookTimeout,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	s.attempts = DefaultWebhookAttempts
+	s.backoff = DefaultWebhookBackoff
+	s.deliveries = make(map[int]*WebhookDelivery)
+	s.logSize = DefaultWebhookLogSize
+	return s
+}
+
+/*
+SetWebhookSecret sets the key with which webhooks are signed. Until it is set, requests
+with a callback URL are refused.
+*/
+func (h *HashHandler) SetWebhookSecret(secret string) {
+	h.webhooks.secret = []byte(secret)
+}
+
+/*
+SetWebhookAllowPrivate selects whether webhooks may be sent to loopback, private, link-local and
+other addresses that are not publicly routable. By default they may not, since that would let
+clients make the server send requests to services on its own network.
+*/
+func (h *HashHandler) SetWebhookAllowPrivate(allow bool) {
+	h.webhooks.allowPrivate = allow
+}
+
+/*
+checkAddress is the dialer control function of the sender's client. It refuses to connect to
+an address that is not publicly routable, unless the sender allows private addresses.
+*/
+func (s *webhookSender) checkAddress(network string, address string, conn syscall.RawConn) error {
+	if s.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return errors.New(fmt.Sprintf("webhook to non-public address %s refused", host))
+	}
+	return nil
+}
+
+/*
+nonPublicNetworks lists the special-purpose networks, beyond those recognized by the methods of
+net.IP, to which webhooks are not sent.
+*/
+var nonPublicNetworks = parseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"100.64.0.0/10",  // shared address space, used for carrier-grade NAT and some cloud metadata services
+	"192.0.0.0/24",   // IETF protocol assignments
+	"198.18.0.0/15",  // benchmarking
+	"240.0.0.0/4",    // reserved, and the limited broadcast address
+	"64:ff9b::/96",   // NAT64, which can reach any IPv4 address
+	"64:ff9b:1::/48", // local-use NAT64
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+/*
+isPublicIP reports whether ip is a publicly routable unicast address.
+*/
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+parseCallbackURL returns callbackURL if it is an absolute http or https URL, and webhooks can be signed.
+A URL whose host is an IP address that is not publicly routable is refused, unless webhooks to
+private addresses are allowed.
+*/
+func (h *HashHandler) parseCallbackURL(callbackURL string) (string, error) {
+	if len(h.webhooks.secret) == 0 {
+		return "", newRequestError(http.StatusBadRequest,
+			errors.New("malformed request: 'callback_url' requires a webhook secret to be configured"))
+	}
+	parsed, err := url.Parse(callbackURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", newRequestError(http.StatusBadRequest,
+			errors.New(fmt.Sprintf("malformed request: invalid 'callback_url' field: %s", callbackURL)))
+	}
+	if ip := net.ParseIP(parsed.Hostname()); ip != nil && !isPublicIP(ip) && !h.webhooks.allowPrivate {
+		return "", newRequestError(http.StatusBadRequest,
+			errors.New(fmt.Sprintf("malformed request: 'callback_url' has a non-public address: %s", callbackURL)))
+	}
+	return callbackURL, nil
+}
+
+/*
+notifyStored sends the webhook announcing that record has been stored under id to callbackURL.
+The caller must have added the delivery to the handler's waitGroup, so that shutdown waits for it.
+*/
+func (h *HashHandler) notifyStored(id int, callbackURL string, record model.HashRecord) {
+	defer h.waitGroup.Done()
+	hash, err := Encode(DefaultEncoding, record.Hash)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	body, err := json.Marshal(WebhookPayload{EventCompleted, hashIdOf(id, record.PublicId), record.Algorithm,
+		hash, record.Completed})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	h.webhooks.deliver(id, callbackURL, body)
+}
+
+/*
+deliver posts body to callbackURL until a 2xx response is received or the attempts run out,
+recording each attempt under id.
+*/
+func (s *webhookSender) deliver(id int, callbackURL string, body []byte) {
+	delivery := s.record(id, callbackURL)
+	backoff := s.backoff
+	for attempt := 1; attempt <= s.attempts; attempt++ {
+		result := s.send(callbackURL, body)
+		s.mutex.Lock()
+		delivery.Attempts = append(delivery.Attempts, result)
+		delivery.Delivered = result.Status >= 200 && result.Status < 300
+		s.mutex.Unlock()
+		if delivery.Delivered {
+			return
+		}
+		log.Printf("webhook for hash %d to %s failed (attempt %d of %d): status %d %s\n",
+			id, callbackURL, attempt, s.attempts, result.Status, result.Error)
+		if attempt < s.attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+/*
+send makes one attempt to post body to callbackURL, signed with the current time.
+*/
+func (s *webhookSender) send(callbackURL string, body []byte) WebhookAttempt {
+	result := WebhookAttempt{Time: time.Now()}
+	request, err := http.NewRequest("POST", callbackURL, bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	timestamp := strconv.FormatInt(result.Time.Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(s.secret, timestamp, body))
+	response, err := s.client.Do(request)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	response.Body.Close()
+	result.Status = response.StatusCode
+	return result
+}
+
+/*
+signWebhook returns the hex HMAC-SHA256, keyed by secret, of timestamp, a ".", and body.
+*/
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+record starts the record of a delivery for id, forgetting the oldest delivery if the log is full.
+*/
+func (s *webhookSender) record(id int, callbackURL string) *WebhookDelivery {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delivery := &WebhookDelivery{URL: callbackURL, Attempts: []WebhookAttempt{}}
+	if _, ok := s.deliveries[id]; !ok {
+		s.order = append(s.order, id)
+	}
+	s.deliveries[id] = delivery
+	if len(s.order) > s.logSize {
+		delete(s.deliveries, s.order[0])
+		s.order = s.order[1:]
+	}
+	return delivery
+}
+
+/*
+getDelivery returns a copy of the record of the webhook delivery for id.
+*/
+func (s *webhookSender) getDelivery(id int) (WebhookDelivery, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delivery, ok := s.deliveries[id]
+	if !ok {
+		return WebhookDelivery{}, false
+	}
+	copied := *delivery
+	copied.Attempts = append([]WebhookAttempt{}, delivery.Attempts...)
+	return copied, true
+}
+
+/*
+handleDeliveries responds with the record of the webhook delivery for the hash identified by reqId.
+The callback URL is left out unless the request has admin authorization.
+*/
+func (h *HashHandler) handleDeliveries(w http.ResponseWriter, request *http.Request, reqId string) error {
+	id, err := h.resolveId(reqId)
+	if err != nil {
+		return err
+	}
+	delivery, ok := h.webhooks.getDelivery(id)
+	if !ok {
+		return errors.New(fmt.Sprintf("no webhook delivery for hash %s", reqId))
+	}
+	if h.isAdmin == nil || !h.isAdmin(request) {
+		delivery.URL = ""
+	}
+	output, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+	return nil
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+func newWebhookTest() (*HashHandler, *sync.WaitGroup) {
+	wg := new(sync.WaitGroup)
+	h := NewHashHandler(model.NewStats(), model.NewMemoryStore(), wg)
+	h.SetDelay(time.Millisecond)
+	h.SetWebhookSecret("secret")
+	h.SetWebhookAllowPrivate(true)
+	h.webhooks.backoff = time.Millisecond
+	return h, wg
+}
+
+func postCallback(h *HashHandler, callbackURL string) *MockResponseWriter {
+	body := "password=angryMonkey&callback_url=" + callbackURL
+	request, _ := http.NewRequest("POST", "/hash", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := new(MockResponseWriter)
+	h.HandleRequest(w, request)
+	return w
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	h, wg := newWebhookTest()
+	var mutex sync.Mutex
+	var received [][]byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		timestamp := request.Header.Get(WebhookTimestampHeader)
+		if request.Header.Get(WebhookSignatureHeader) != "sha256="+signWebhook([]byte("secret"), timestamp, body) {
+			t.Errorf("Invalid signature %s", request.Header.Get(WebhookSignatureHeader))
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, body)
+		if len(received) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	w := postCallback(h, receiver.URL+"/done")
+	if w.LastStatus != 0 || string(w.LastData) != "1" {
+		t.Fatalf("Unexpected response %d %s", w.LastStatus, w.LastData)
+	}
+	// Shutdown waits for the hash and then its delivery, including retries.
+	wg.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(received) != 3 || !bytes.Equal(received[0], received[2]) {
+		t.Fatalf("Expected the same webhook 3 times, got %d", len(received))
+	}
+	var payload WebhookPayload
+	json.Unmarshal(received[2], &payload)
+	hash, _ := Encode(DefaultEncoding, h.getHash(1))
+	if payload.Event != EventCompleted || payload.Id != "1" || payload.Hash != hash || payload.Completed.IsZero() {
+		t.Errorf("Unexpected payload %s", received[2])
+	}
+
+	request, _ := http.NewRequest("GET", "/hash/1/deliveries", nil)
+	w = new(MockResponseWriter)
+	h.HandleRequest(w, request)
+	var delivery WebhookDelivery
+	json.Unmarshal(w.LastData, &delivery)
+	if !delivery.Delivered || delivery.URL != "" || len(delivery.Attempts) != 3 ||
+		delivery.Attempts[0].Status != http.StatusServiceUnavailable || delivery.Attempts[2].Status != http.StatusOK {
+		t.Errorf("Unexpected delivery record %s", w.LastData)
+	}
+
+	admin := NewAdminHandler(h)
+	admin.SetToken("secret")
+	h.SetAdminAuthorizer(admin.IsAuthorized)
+	request.Header.Set("Authorization", "Bearer secret")
+	w = new(MockResponseWriter)
+	h.HandleRequest(w, request)
+	delivery = WebhookDelivery{}
+	json.Unmarshal(w.LastData, &delivery)
+	if delivery.URL != receiver.URL+"/done" {
+		t.Errorf("Expected admin to see the callback URL, got %s", w.LastData)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	h, wg := newWebhookTest()
+	h.webhooks.attempts = 3
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	receiverURL := receiver.URL
+	receiver.Close()
+
+	postCallback(h, receiverURL)
+	wg.Wait()
+	delivery, ok := h.webhooks.getDelivery(1)
+	if !ok || delivery.Delivered || len(delivery.Attempts) != 3 || delivery.Attempts[2].Error == "" {
+		t.Errorf("Unexpected delivery record %+v", delivery)
+	}
+}
+
+func TestWebhookInvalidCallback(t *testing.T) {
+	h, _ := newWebhookTest()
+	for _, callbackURL := range []string{"ftp://example.com/", "/relative", "http://"} {
+		if w := postCallback(h, callbackURL); w.LastStatus != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, callbackURL, w.LastStatus)
+		}
+	}
+	h.SetWebhookSecret("")
+	if w := postCallback(h, "http://example.com/"); w.LastStatus != http.StatusBadRequest {
+		t.Errorf("Expected status %d without a webhook secret, got %d", http.StatusBadRequest, w.LastStatus)
+	}
+	request, _ := http.NewRequest("GET", "/hash/1/deliveries", nil)
+	w := new(MockResponseWriter)
+	h.HandleRequest(w, request)
+	if w.LastStatus != http.StatusNotFound {
+		t.Errorf("Expected status %d for no delivery, got %d", http.StatusNotFound, w.LastStatus)
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	h, wg := newWebhookTest()
+	h.SetWebhookAllowPrivate(false)
+	h.webhooks.attempts = 1
+	for _, callbackURL := range []string{"http://127.0.0.1:8080/", "http://10.1.2.3/", "http://[::1]/",
+		"http://169.254.169.254/latest/meta-data/", "http://100.100.100.200/latest/meta-data/",
+		"http://0.1.2.3/", "http://[64:ff9b::a01:203]/", "http://[::ffff:127.0.0.1]/"} {
+		if w := postCallback(h, callbackURL); w.LastStatus != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, callbackURL, w.LastStatus)
+		}
+	}
+
+	var received atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		received.Store(true)
+	}))
+	defer receiver.Close()
+	// A host name is only resolved when the webhook is sent.
+	callbackURL := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+	if w := postCallback(h, callbackURL); w.LastStatus != 0 {
+		t.Fatalf("Unexpected status %d for %s", w.LastStatus, callbackURL)
+	}
+	wg.Wait()
+	delivery, ok := h.webhooks.getDelivery(1)
+	if received.Load() || !ok || delivery.Delivered || !strings.Contains(delivery.Attempts[0].Error, "non-public") {
+		t.Errorf("Expected delivery to %s to be refused, got %+v", callbackURL, delivery)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.215.14": true, "2606:2800:21f:cb07::1": true, "100.63.255.255": true, "100.128.0.0": true,
+		"100.64.0.1": false, "100.100.100.200": false, "0.0.0.1": false, "255.255.255.255": false,
+		"64:ff9b::808:808": false, "fd00::1": false, "fe80::1": false,
+	} {
+		if isPublicIP(net.ParseIP(address)) != public {
+			t.Errorf("Expected isPublicIP(%s) to be %t", address, public)
+		}
+	}
+}
+
+func TestWebhookDoesNotFollowRedirects(t *testing.T) {
+	h, wg := newWebhookTest()
+	h.webhooks.attempts = 1
+	var redirected atomic.Bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		redirected.Store(true)
+	}))
+	defer target.Close()
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		http.Redirect(w, request, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer receiver.Close()
+
+	postCallback(h, receiver.URL)
+	wg.Wait()
+	delivery, _ := h.webhooks.getDelivery(1)
+	if redirected.Load() || delivery.Delivered || delivery.Attempts[0].Status != http.StatusTemporaryRedirect {
+		t.Errorf("Expected the redirect to be recorded and not followed, got %+v", delivery)
+	}
+}
+
+func TestWebhookLogSize(t *testing.T) {
+	s := newWebhookSender()
+	s.logSize = 2
+	s.record(1, "a")
+	s.record(2, "b")
+	s.record(3, "c")
+	if _, ok := s.getDelivery(1); ok {
+		t.Errorf("Expected oldest delivery to be forgotten")
+	}
+	if delivery, ok := s.getDelivery(3); !ok || delivery.URL != "c" {
+		t.Errorf("Expected newest delivery to be kept")
+	}
+}
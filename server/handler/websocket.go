@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is appended to a client's key to compute the Sec-WebSocket-Accept header (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsWriteTimeout bounds how long writing one frame may block on a client that is not reading.
+const wsWriteTimeout = 10 * time.Second
+
+// Frame opcodes (RFC 6455, section 5.2).
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes (RFC 6455, section 7.4.1).
+const (
+	closeNormal          = 1000
+	closeGoingAway       = 1001
+	closeProtocolError   = 1002
+	closeUnsupportedData = 1003
+	closeInvalidPayload  = 1007
+	closeTooBig          = 1009
+)
+
+var errWebSocketClosed = errors.New("websocket: close frame already sent")
+
+/*
+A wsCloseError is a failure of the client to follow the protocol, which ends the connection
+with the given close status.
+*/
+type wsCloseError struct {
+	code   int
+	reason string
+}
+
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket: %s (close status %d)", e.reason, e.code)
+}
+
+/*
+A wsConn is the server side of a WebSocket connection, as specified by RFC 6455.
+Messages may be read by one goroutine at a time, and frames may be written by any number.
+Each frame read extends the read deadline by readTimeout, if it is set, so that a client
+that stops responding to pings is eventually disconnected.
+*/
+type wsConn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	maxMessageSize int
+	readTimeout    time.Duration
+	writeMutex     sync.Mutex
+	closeSent      bool
+}
+
+/*
+upgradeWebSocket completes the opening handshake of a WebSocket connection requested by request,
+and takes over its connection from the http server. A request that is not a valid handshake is
+refused with a requestError, without taking over the connection.
+*/
+func upgradeWebSocket(w http.ResponseWriter, request *http.Request, maxMessageSize int) (*wsConn, error) {
+	if !headerHasToken(request.Header, "Connection", "upgrade") || !headerHasToken(request.Header, "Upgrade", "websocket") {
+		return nil, newRequestError(http.StatusBadRequest, errors.New("malformed request: not a websocket handshake"))
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, newRequestError(http.StatusUpgradeRequired,
+			errors.New(fmt.Sprintf("unsupported websocket version: %s", request.Header.Get("Sec-WebSocket-Version"))))
+	}
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, newRequestError(http.StatusBadRequest,
+			errors.New(fmt.Sprintf("malformed request: invalid Sec-WebSocket-Key: %s", key)))
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, newRequestError(http.StatusInternalServerError, errors.New("websocket: connection cannot be taken over"))
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	fmt.Fprintf(buffered.Writer, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err := buffered.Writer.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: buffered.Reader, maxMessageSize: maxMessageSize}, nil
+}
+
+/*
+websocketAccept returns the Sec-WebSocket-Accept header value that answers the client's key.
+*/
+func websocketAccept(key string) string {
+	digest := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+/*
+headerHasToken reports whether the comma-separated values of the named header include token,
+ignoring case.
+*/
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, element := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(element), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+readMessage returns the next text or binary message from the client, reassembled from its frames.
+Pings are answered as they arrive, and pongs are discarded. When the client closes the connection,
+it returns opClose.
+A client that breaks the protocol, or sends a message longer than maxMessageSize, gets a wsCloseError.
+*/
+func (c *wsConn) readMessage() (int, []byte, error) {
+	opcode := -1
+	var message []byte
+	for {
+		fin, frameOpcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOpcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil && err != errWebSocketClosed {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			if len(payload) == 1 {
+				return 0, nil, &wsCloseError{closeProtocolError, "truncated close status"}
+			}
+			return opClose, nil, nil
+		case opContinuation:
+			if opcode < 0 {
+				return 0, nil, &wsCloseError{closeProtocolError, "continuation without a message"}
+			}
+		case opText, opBinary:
+			if opcode >= 0 {
+				return 0, nil, &wsCloseError{closeProtocolError, "new message before the last was finished"}
+			}
+			opcode = frameOpcode
+		default:
+			return 0, nil, &wsCloseError{closeProtocolError, fmt.Sprintf("unknown opcode %d", frameOpcode)}
+		}
+		if len(message)+len(payload) > c.maxMessageSize {
+			return 0, nil, &wsCloseError{closeTooBig, "message too long"}
+		}
+		message = append(message, payload...)
+		if fin {
+			if opcode == opText && !utf8.Valid(message) {
+				return 0, nil, &wsCloseError{closeInvalidPayload, "text message is not UTF-8"}
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+/*
+readFrame reads and unmasks one frame from the client.
+*/
+func (c *wsConn) readFrame() (bool, int, []byte, error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &wsCloseError{closeProtocolError, "reserved bits set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &wsCloseError{closeProtocolError, "unmasked client frame"}
+	}
+	length := uint64(header[1] & 0x7f)
+	if opcode >= opClose && (!fin || length > 125) {
+		return false, 0, nil, &wsCloseError{closeProtocolError, "invalid control frame"}
+	}
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > uint64(c.maxMessageSize) {
+		return false, 0, nil, &wsCloseError{closeTooBig, "message too long"}
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+/*
+writeFrame sends payload to the client as a single unmasked frame.
+Nothing may be sent once a close frame has been sent; writeFrame then returns errWebSocketClosed.
+*/
+func (c *wsConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	if c.closeSent {
+		return errWebSocketClosed
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+/*
+writeClose begins or completes the closing handshake by sending a close frame with the given
+status and reason. It does nothing if a close frame has already been sent.
+*/
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	err := c.writeFrame(opClose, append(payload, reason...))
+	if err == errWebSocketClosed {
+		return nil
+	}
+	return err
+}
+
+/*
+close closes the underlying connection, ending any read in progress.
+*/
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}
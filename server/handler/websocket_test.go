@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestWebsocketAccept(t *testing.T) {
+	// The example handshake of RFC 6455, section 1.3.
+	accept := websocketAccept("dGhlIHNhbXBsZSBub25jZQ==")
+	if accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected Sec-WebSocket-Accept %s", accept)
+	}
+}
+
+/*
+clientFrame returns a frame as a client would send it, masked.
+*/
+func clientFrame(fin bool, opcode int, payload []byte) []byte {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+/*
+newTestConn returns a wsConn that reads the given client frames, and the client end of its connection.
+*/
+func newTestConn(frames ...[]byte) (*wsConn, net.Conn) {
+	server, client := net.Pipe()
+	c := &wsConn{conn: server, reader: bufio.NewReader(bytes.NewReader(bytes.Join(frames, nil))), maxMessageSize: 1000}
+	return c, client
+}
+
+func TestReadFragmentedMessage(t *testing.T) {
+	c, _ := newTestConn(
+		clientFrame(false, opText, []byte("hello, ")),
+		clientFrame(true, opPong, nil),
+		clientFrame(true, opContinuation, bytes.Repeat([]byte("w"), 200)))
+	opcode, message, err := c.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opcode != opText || string(message) != "hello, "+string(bytes.Repeat([]byte("w"), 200)) {
+		t.Errorf("Unexpected message %d %q", opcode, message)
+	}
+}
+
+func TestReadPingIsAnswered(t *testing.T) {
+	c, client := newTestConn(clientFrame(true, opPing, []byte("hi")), clientFrame(true, opClose, []byte{0x03, 0xe8}))
+	pong := make(chan []byte)
+	go func() {
+		frame := make([]byte, 4)
+		client.Read(frame)
+		pong <- frame
+	}()
+	opcode, _, err := c.readMessage()
+	if err != nil || opcode != opClose {
+		t.Errorf("Expected close, got %d %v", opcode, err)
+	}
+	if frame := <-pong; !bytes.Equal(frame, []byte{0x80 | opPong, 2, 'h', 'i'}) {
+		t.Errorf("Unexpected pong frame %v", frame)
+	}
+}
+
+func TestReadProtocolErrors(t *testing.T) {
+	unmasked := clientFrame(true, opText, []byte("x"))
+	unmasked[1] &^= 0x80
+	tests := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"unmasked", unmasked, closeProtocolError},
+		{"reserved bits", append([]byte{0x40 | 0x80 | opText}, clientFrame(true, opText, nil)[1:]...), closeProtocolError},
+		{"continuation", clientFrame(true, opContinuation, []byte("x")), closeProtocolError},
+		{"fragmented ping", clientFrame(false, opPing, nil), closeProtocolError},
+		{"unknown opcode", clientFrame(true, 0x3, nil), closeProtocolError},
+		{"too long", clientFrame(true, opText, make([]byte, 1001)), closeTooBig},
+		{"invalid UTF-8", clientFrame(true, opText, []byte{0xff}), closeInvalidPayload},
+	}
+	for _, test := range tests {
+		c, _ := newTestConn(test.frame)
+		_, _, err := c.readMessage()
+		var closeErr *wsCloseError
+		if !errors.As(err, &closeErr) || closeErr.code != test.code {
+			t.Errorf("%s: expected close status %d, got %v", test.name, test.code, err)
+		}
+	}
+}
+
+func TestNoWritesAfterClose(t *testing.T) {
+	c, client := newTestConn()
+	go func() {
+		client.Read(make([]byte, 100))
+	}()
+	if err := c.writeClose(closeNormal, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.writeFrame(opText, []byte("late")); err != errWebSocketClosed {
+		t.Errorf("Expected errWebSocketClosed, got %v", err)
+	}
+	if err := c.writeClose(closeNormal, ""); err != nil {
+		t.Errorf("Expected second close to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package model
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// recordOverhead approximates the memory used by a stored record beyond its variable-length fields.
+const recordOverhead = 128
+
+/*
+An EvictionPolicy selects which record a BoundedStore evicts to make room for a new one.
+*/
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the record that was least recently stored or retrieved.
+	EvictLRU EvictionPolicy = iota
+	// EvictOldest evicts the record that was stored first.
+	EvictOldest
+)
+
+/*
+ParseEvictionPolicy returns the EvictionPolicy named by name, which is either "lru" or "oldest".
+*/
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case "lru":
+		return EvictLRU, nil
+	case "oldest":
+		return EvictOldest, nil
+	}
+	return EvictLRU, errors.New(fmt.Sprintf("unknown eviction policy: %s", name))
+}
+
+/*
+ErrStoreFull is returned by BoundedStore.Put when a new record does not fit and the store rejects
+new records rather than evicting old ones.
+*/
+var ErrStoreFull = errors.New("store is full")
+
+/*
+BoundedStoreOptions limit the size of a BoundedStore. A zero limit is not enforced.
+If Reject is set, new records that do not fit are refused with ErrStoreFull,
+and otherwise records are evicted according to Policy until they fit.
+*/
+type BoundedStoreOptions struct {
+	MaxRecords int
+	MaxBytes   int64
+	Policy     EvictionPolicy
+	Reject     bool
+}
+
+/*
+An EvictionNotifier is a HashStore that can report the records it evicts.
+*/
+type EvictionNotifier interface {
+	// OnEvict sets a function to be called with the id and contents of each evicted record.
+	// It is called while the store is locked, and so must not use the store.
+	OnEvict(f func(id int, record HashRecord))
+}
+
+/*
+A CapacityLimiter is a HashStore that may refuse new records once it is full.
+*/
+type CapacityLimiter interface {
+	// HasRoom reports whether n more records, of about size bytes in all, can be stored.
+	HasRoom(n int, size int64) bool
+}
+
+/*
+A Peeker is a HashStore that can look up a record without affecting which records it evicts.
+*/
+type Peeker interface {
+	Peek(id int) (HashRecord, bool)
+}
+
+/*
+Peek returns the record stored under id, without counting as a use of the record
+if store is a Peeker.
+*/
+func Peek(store HashStore, id int) (HashRecord, bool) {
+	if peeker, ok := store.(Peeker); ok {
+		return peeker.Peek(id)
+	}
+	return store.Get(id)
+}
+
+/*
+A BoundedStore is a HashStore that limits the number of records, or their approximate size in
+bytes, held by another HashStore. Once a limit is reached, each new record either evicts old
+records or is rejected, as selected by BoundedStoreOptions.
+
+A BoundedStore is an IdTracker, so that the ids of evicted records are not assigned again,
+and an io.Closer that closes the store it wraps, if that store is one.
+*/
+type BoundedStore struct {
+	store    HashStore
+	options  BoundedStoreOptions
+	order    *list.List
+	elements map[int]*list.Element
+	sizes    map[int]int64
+	bytes    int64
+	maxId    int
+	onEvict  func(id int, record HashRecord)
+	mutex    sync.Mutex
+}
+
+/*
+NewBoundedStore returns a BoundedStore that limits the records held by store.
+The records already in store are treated as having been stored in order of id, and if they
+exceed the limits, the earliest are evicted, unless options.Reject is set.
+*/
+func NewBoundedStore(store HashStore, options BoundedStoreOptions) (*BoundedStore, error) {
+	if options.MaxRecords < 0 || options.MaxBytes < 0 {
+		return nil, errors.New(fmt.Sprintf("invalid store limits: %d records, %d bytes",
+			options.MaxRecords, options.MaxBytes))
+	}
+	s := new(BoundedStore)
+	s.store = store
+	s.options = options
+	s.order = list.New()
+	s.elements = make(map[int]*list.Element)
+	s.sizes = make(map[int]int64)
+	s.maxId = LastId(store)
+	for _, id := range store.List() {
+		record, _ := store.Get(id)
+		s.track(id, record)
+	}
+	if !options.Reject {
+		if err := s.evict(0); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+/*
+OnEvict sets a function to be called with the id and contents of each record evicted from the store.
+*/
+func (s *BoundedStore) OnEvict(f func(id int, record HashRecord)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onEvict = f
+}
+
+func (s *BoundedStore) Put(id int, record HashRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, replacing := s.elements[id]
+	size := RecordSize(record)
+	if !replacing && s.options.Reject && !s.fits(1, size) {
+		return ErrStoreFull
+	}
+	if err := s.store.Put(id, record); err != nil {
+		return err
+	}
+	if id > s.maxId {
+		s.maxId = id
+	}
+	s.track(id, record)
+	if s.options.Reject {
+		return nil
+	}
+	return s.evict(id)
+}
+
+/*
+Get returns the record stored under id, and counts as a use of the record for EvictLRU.
+*/
+func (s *BoundedStore) Get(id int) (HashRecord, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	record, ok := s.store.Get(id)
+	if element, tracked := s.elements[id]; ok && tracked && s.options.Policy == EvictLRU {
+		s.order.MoveToBack(element)
+	}
+	return record, ok
+}
+
+func (s *BoundedStore) Peek(id int) (HashRecord, bool) {
+	return s.store.Get(id)
+}
+
+func (s *BoundedStore) Delete(id int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.store.Delete(id); err != nil {
+		return err
+	}
+	s.untrack(id)
+	return nil
+}
+
+func (s *BoundedStore) List() []int {
+	return s.store.List()
+}
+
+func (s *BoundedStore) Len() int {
+	return s.store.Len()
+}
+
+/*
+Bytes returns the approximate size of the stored records.
+*/
+func (s *BoundedStore) Bytes() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.bytes
+}
+
+/*
+HasRoom reports whether n more records, whose sizes as given by RecordSize total size bytes,
+can be stored within the limits. It always reports true if the store evicts records to make room.
+*/
+func (s *BoundedStore) HasRoom(n int, size int64) bool {
+	if !s.options.Reject {
+		return true
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.fits(n, size)
+}
+
+func (s *BoundedStore) MaxId() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if tracker, ok := s.store.(IdTracker); ok && tracker.MaxId() > s.maxId {
+		return tracker.MaxId()
+	}
+	return s.maxId
+}
+
+func (s *BoundedStore) ReserveIds(maxId int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if tracker, ok := s.store.(IdTracker); ok {
+		if err := tracker.ReserveIds(maxId); err != nil {
+			return err
+		}
+	}
+	if maxId > s.maxId {
+		s.maxId = maxId
+	}
+	return nil
+}
+
+func (s *BoundedStore) Close() error {
+	if closer, ok := s.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+/*
+fits reports whether n more records totalling size bytes can be stored within the limits.
+*/
+func (s *BoundedStore) fits(n int, size int64) bool {
+	if s.options.MaxRecords > 0 && len(s.elements)+n > s.options.MaxRecords {
+		return false
+	}
+	if s.options.MaxBytes > 0 && s.bytes+size > s.options.MaxBytes {
+		return false
+	}
+	return true
+}
+
+/*
+track records that id was just stored or replaced, updating its size and order.
+*/
+func (s *BoundedStore) track(id int, record HashRecord) {
+	size := RecordSize(record)
+	s.bytes += size - s.sizes[id]
+	s.sizes[id] = size
+	if element, ok := s.elements[id]; ok {
+		if s.options.Policy == EvictLRU {
+			s.order.MoveToBack(element)
+		}
+		return
+	}
+	s.elements[id] = s.order.PushBack(id)
+}
+
+func (s *BoundedStore) untrack(id int) {
+	if element, ok := s.elements[id]; ok {
+		s.order.Remove(element)
+		delete(s.elements, id)
+	}
+	s.bytes -= s.sizes[id]
+	delete(s.sizes, id)
+}
+
+/*
+evict removes records in eviction order until the store is within its limits.
+The record stored under keep is never evicted, even if it alone exceeds the byte limit.
+*/
+func (s *BoundedStore) evict(keep int) error {
+	for !s.fits(0, 0) {
+		element := s.order.Front()
+		if element == nil {
+			return nil
+		}
+		id := element.Value.(int)
+		if id == keep {
+			if element.Next() == nil {
+				return nil
+			}
+			id = element.Next().Value.(int)
+		}
+		record, _ := s.store.Get(id)
+		if err := s.store.Delete(id); err != nil {
+			return err
+		}
+		s.untrack(id)
+		if s.onEvict != nil {
+			s.onEvict(id, record)
+		}
+	}
+	return nil
+}
+
+/*
+RecordSize approximates the memory used to store record, as counted against a byte limit.
+*/
+func RecordSize(record HashRecord) int64 {
+	return int64(recordOverhead + len(record.Algorithm) + len(record.Hash) + len(record.Salt) + len(record.KeyId))
+}
@@ -0,0 +1,117 @@
+package model_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ifIMust/encodeServer/server/model"
+	"github.com/ifIMust/encodeServer/server/model/storetest"
+)
+
+func newBoundedStore(t *testing.T, store model.HashStore, options model.BoundedStoreOptions) *model.BoundedStore {
+	s, err := model.NewBoundedStore(store, options)
+	if err != nil {
+		t.Fatalf("Failed to create bounded store: %s", err)
+	}
+	return s
+}
+
+func putIds(s model.HashStore, ids ...int) {
+	for _, id := range ids {
+		s.Put(id, model.HashRecord{Algorithm: "sha256", Hash: []byte{byte(id)}})
+	}
+}
+
+func TestBoundedStore(t *testing.T) {
+	storetest.TestHashStore(t, func(t *testing.T) model.HashStore {
+		return newBoundedStore(t, model.NewMemoryStore(), model.BoundedStoreOptions{})
+	})
+}
+
+func TestBoundedStoreEvictsLRU(t *testing.T) {
+	s := newBoundedStore(t, model.NewMemoryStore(), model.BoundedStoreOptions{MaxRecords: 3})
+	var evicted []int
+	s.OnEvict(func(id int, record model.HashRecord) {
+		evicted = append(evicted, id)
+	})
+	putIds(s, 1, 2, 3)
+	s.Get(1)
+	putIds(s, 4, 5)
+	if !reflect.DeepEqual(s.List(), []int{1, 4, 5}) || !reflect.DeepEqual(evicted, []int{2, 3}) {
+		t.Errorf("Expected to keep [1 4 5] and evict [2 3], kept %v and evicted %v", s.List(), evicted)
+	}
+	if s.MaxId() != 5 {
+		t.Errorf("Expected max id %d, had %d", 5, s.MaxId())
+	}
+}
+
+func TestBoundedStoreEvictsOldest(t *testing.T) {
+	s := newBoundedStore(t, model.NewMemoryStore(), model.BoundedStoreOptions{MaxRecords: 3, Policy: model.EvictOldest})
+	putIds(s, 1, 2, 3)
+	s.Get(1)
+	putIds(s, 2, 4)
+	if !reflect.DeepEqual(s.List(), []int{2, 3, 4}) {
+		t.Errorf("Expected to keep [2 3 4], kept %v", s.List())
+	}
+}
+
+func TestBoundedStoreByteLimit(t *testing.T) {
+	s := newBoundedStore(t, model.NewMemoryStore(), model.BoundedStoreOptions{})
+	putIds(s, 1)
+	size := s.Bytes()
+	s = newBoundedStore(t, model.NewMemoryStore(), model.BoundedStoreOptions{MaxBytes: 2 * size})
+	putIds(s, 1, 2, 3)
+	if !reflect.DeepEqual(s.List(), []int{2, 3}) || s.Bytes() != 2*size {
+		t.Errorf("Expected to keep [2 3] in %d bytes, kept %v in %d bytes", 2*size, s.List(), s.Bytes())
+	}
+	s.Delete(2)
+	if s.Bytes() != size {
+		t.Errorf("Expected %d bytes after delete, had %d", size, s.Bytes())
+	}
+}
+
+func TestBoundedStoreRejects(t *testing.T) {
+	s := newBoundedStore(t, model.NewMemoryStore(), model.BoundedStoreOptions{MaxRecords: 2, Reject: true})
+	putIds(s, 1)
+	if !s.HasRoom(1, 0) || s.HasRoom(2, 0) {
+		t.Errorf("Expected room for exactly one more record")
+	}
+	putIds(s, 2)
+	if err := s.Put(3, model.HashRecord{Algorithm: "sha256", Hash: []byte{3}}); err != model.ErrStoreFull {
+		t.Errorf("Expected %s, got %v", model.ErrStoreFull, err)
+	}
+	if err := s.Put(2, model.HashRecord{Algorithm: "sha256", Hash: []byte{4}}); err != nil {
+		t.Errorf("Replacing a record in a full store produced error %s", err)
+	}
+	if !reflect.DeepEqual(s.List(), []int{1, 2}) {
+		t.Errorf("Expected to keep [1 2], kept %v", s.List())
+	}
+}
+
+func TestBoundedStoreHasRoomForPendingBytes(t *testing.T) {
+	record := model.HashRecord{Algorithm: "sha256", Hash: []byte{1}}
+	size := model.RecordSize(record)
+	s := newBoundedStore(t, model.NewMemoryStore(), model.BoundedStoreOptions{MaxBytes: 3 * size, Reject: true})
+	s.Put(1, record)
+	if !s.HasRoom(2, 2*size) || s.HasRoom(2, 2*size+1) {
+		t.Errorf("Expected room for exactly %d more bytes with %d bytes stored", 2*size, s.Bytes())
+	}
+}
+
+func TestBoundedStoreWrapsExistingRecords(t *testing.T) {
+	dir := t.TempDir()
+	log := openLogStore(t, dir, model.LogStoreOptions{})
+	putIds(log, 1, 2, 3)
+	s := newBoundedStore(t, log, model.BoundedStoreOptions{MaxRecords: 2})
+	if !reflect.DeepEqual(s.List(), []int{2, 3}) {
+		t.Errorf("Expected to keep [2 3], kept %v", s.List())
+	}
+	putIds(s, 4)
+	s.Delete(4)
+	s.Close()
+	log = openLogStore(t, dir, model.LogStoreOptions{})
+	defer log.Close()
+	if model.LastId(log) != 4 || log.Len() != 1 {
+		t.Errorf("Expected 1 record and last id %d after reopening, had %d and %d", 4, log.Len(), model.LastId(log))
+	}
+}
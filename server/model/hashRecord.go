@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+/*
+A HashRecord is a stored hash along with the name of the algorithm that produced it.
+The hash is kept as raw bytes, so that it can be encoded in any supported form when retrieved.
+Salted algorithms also record the salt and iteration count used to derive the hash.
+Peppered hashes record the ID of the secret key that was applied to the password.
+Created is the time the hash was requested, and Completed is the time it was stored.
+Expires, if not zero, is the time after which the record is no longer served and may be deleted.
+PublicId, if not empty, is the opaque id by which clients refer to the record instead of its id.
+*/
+type HashRecord struct {
+	Algorithm  string
+	Hash       []byte
+	Salt       []byte
+	Iterations int
+	KeyId      string
+	Created    time.Time
+	Completed  time.Time
+	Expires    time.Time
+	PublicId   string
+}
+
+/*
+IsExpired reports whether the record has an expiry time that is not after now.
+*/
+func (r HashRecord) IsExpired(now time.Time) bool {
+	return !r.Expires.IsZero() && !r.Expires.After(now)
+}
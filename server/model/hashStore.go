@@ -0,0 +1,43 @@
+package model
+
+/*
+A HashStore holds HashRecords by id. Implementations must be safe for concurrent use.
+*/
+type HashStore interface {
+	// Put stores record under id, replacing any record already stored there.
+	Put(id int, record HashRecord) error
+	// Get returns the record stored under id, and whether there was one.
+	Get(id int) (HashRecord, bool)
+	// Delete removes the record stored under id. Deleting a missing id is not an error.
+	Delete(id int) error
+	// List returns the ids of all stored records in ascending order.
+	List() []int
+	// Len returns the number of stored records.
+	Len() int
+}
+
+/*
+An IdTracker is a HashStore that remembers the highest id it has ever stored, even if the record
+stored under that id has since been deleted. A handler resuming from such a store can continue
+assigning ids after MaxId without reusing any.
+*/
+type IdTracker interface {
+	MaxId() int
+	// ReserveIds raises MaxId to at least maxId, without storing a record.
+	ReserveIds(maxId int) error
+}
+
+/*
+LastId returns the highest id that store has used: its MaxId if it is an IdTracker,
+and otherwise the highest id of any stored record.
+*/
+func LastId(store HashStore) int {
+	if tracker, ok := store.(IdTracker); ok {
+		return tracker.MaxId()
+	}
+	ids := store.List()
+	if len(ids) == 0 {
+		return 0
+	}
+	return ids[len(ids)-1]
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package model
+
+import "os"
+
+/*
+lockFile does nothing on platforms without advisory file locks; a log directory must not be
+opened by more than one process at a time.
+*/
+func lockFile(file *os.File) error {
+	return nil
+}
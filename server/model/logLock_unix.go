@@ -0,0 +1,20 @@
+//go:build unix
+
+package model
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+lockFile takes an exclusive advisory lock on file without waiting, and reports ErrLogLocked if
+another open file holds it. The lock is released when file is closed.
+*/
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLogLocked
+	}
+	return err
+}
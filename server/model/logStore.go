@@ -0,0 +1,651 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A SyncPolicy determines when a LogStore flushes appended entries to stable storage.
+*/
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log after every append, before the append returns.
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodic flushes the log in the background, once per sync interval, if it has changed.
+	SyncPeriodic
+	// SyncNever leaves flushing the log to the operating system.
+	SyncNever
+)
+
+const (
+	// DefaultSyncInterval is the sync interval of a LogStore using SyncPeriodic, unless otherwise configured.
+	DefaultSyncInterval = time.Second
+	// DefaultMaxSegmentSize is the size in bytes at which a LogStore starts a new segment, unless otherwise configured.
+	DefaultMaxSegmentSize = 64 << 20
+	// DefaultCompactInterval is how often a LogStore considers compacting its segments, unless otherwise configured.
+	DefaultCompactInterval = time.Minute
+
+	// A LogStore compacts when its segments hold at least this many superseded entries,
+	// and at least as many superseded entries as live records.
+	minCompactGarbage = 1000
+
+	logHeaderSize     = 8
+	maxLogEntrySize   = 1 << 20
+	logOpPut          = "put"
+	logOpDelete       = "delete"
+	logOpBase         = "base"
+	logOpReserve      = "reserve"
+	segmentPrefix     = "segment-"
+	segmentSuffix     = ".log"
+	lockFileName      = "lock"
+	compactTempSuffix = ".compact"
+)
+
+// ErrLogLocked is returned when opening a log directory that another LogStore has open.
+var ErrLogLocked = errors.New("log directory is in use by another process")
+
+var logChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+var syncPolicyNames = map[string]SyncPolicy{
+	"always":   SyncAlways,
+	"periodic": SyncPeriodic,
+	"never":    SyncNever,
+}
+
+/*
+ParseSyncPolicy returns the SyncPolicy named "always", "periodic" or "never".
+*/
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	policy, ok := syncPolicyNames[name]
+	if !ok {
+		return SyncAlways, errors.New(fmt.Sprintf("unknown sync policy: %s", name))
+	}
+	return policy, nil
+}
+
+/*
+LogStoreOptions configures a LogStore. Zero values select the corresponding defaults.
+*/
+type LogStoreOptions struct {
+	SyncPolicy      SyncPolicy
+	SyncInterval    time.Duration
+	MaxSegmentSize  int64
+	CompactInterval time.Duration
+}
+
+/*
+A LogStore is a threadsafe HashStore that persists its records to an append-only log.
+Every Put and Delete appends a checksummed entry to the log. When the log is opened, its entries
+are replayed to rebuild an in-memory index of the records, which serves all reads. A torn or
+corrupt entry at the end of the log, as left by a crash during an append, is truncated away.
+
+The log is a directory of numbered segment files. Entries are appended to the highest-numbered
+segment until it reaches the maximum segment size, and then a new segment is started.
+In the background, the store periodically compacts the log once enough of its entries have been
+superseded by later ones, or once any record has been deleted: it writes every live record to a
+new segment, atomically renames that segment over the newest sealed segment, and removes the
+older ones. A deleted record is therefore erased from disk within one compact interval, rather
+than lingering in old segments. A compacted segment begins with a base entry, which tells replay
+to discard everything that came before it. Compaction never modifies the in-memory index, so
+reads never observe a partially compacted log.
+
+While a LogStore is open, it holds an exclusive lock on the file named "lock" in the directory,
+so that no other process can open the same log, and modify it underneath the store.
+
+Each log entry is an 8-byte header followed by a JSON payload. The header holds the payload
+length and the CRC-32C checksum of the payload, both as big-endian 32-bit integers.
+*/
+type LogStore struct {
+	index        *MemoryStore
+	dir          string
+	lock         *os.File
+	options      LogStoreOptions
+	file         *os.File
+	segment      int
+	offset       int64
+	entries      map[int]int
+	maxId        int
+	dirty        bool
+	deleted      bool
+	done         chan struct{}
+	mutex        sync.Mutex
+	compactMutex sync.Mutex
+}
+
+/*
+A logEntry is used for marshaling one LogStore operation to JSON.
+*/
+type logEntry struct {
+	Op     string      `json:"op"`
+	Id     int         `json:"id"`
+	Record *HashRecord `json:"record,omitempty"`
+}
+
+/*
+OpenLogStore opens the log in directory dir, creating it if it does not exist, and replays it.
+It returns ErrLogLocked if another store has the log open.
+The returned store should be closed when it is no longer needed.
+*/
+func OpenLogStore(dir string, options LogStoreOptions) (*LogStore, error) {
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = DefaultSyncInterval
+	}
+	if options.MaxSegmentSize <= 0 {
+		options.MaxSegmentSize = DefaultMaxSegmentSize
+	}
+	if options.CompactInterval <= 0 {
+		options.CompactInterval = DefaultCompactInterval
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(lock)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	s := new(LogStore)
+	s.index = NewMemoryStore()
+	s.dir = dir
+	s.lock = lock
+	s.options = options
+	s.entries = make(map[int]int)
+	s.done = make(chan struct{})
+	err = s.replay()
+	if err != nil {
+		if s.file != nil {
+			s.file.Close()
+		}
+		lock.Close()
+		return nil, err
+	}
+	if options.SyncPolicy == SyncPeriodic {
+		go s.syncPeriodically()
+	}
+	go s.compactPeriodically()
+	return s, nil
+}
+
+func (s *LogStore) Put(id int, record HashRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := s.append(logEntry{logOpPut, id, &record})
+	if err != nil {
+		return err
+	}
+	return s.index.Put(id, record)
+}
+
+func (s *LogStore) Get(id int) (HashRecord, bool) {
+	return s.index.Get(id)
+}
+
+func (s *LogStore) Delete(id int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.index.Get(id); !ok {
+		return nil
+	}
+	err := s.append(logEntry{Op: logOpDelete, Id: id})
+	if err != nil {
+		return err
+	}
+	s.deleted = true
+	return s.index.Delete(id)
+}
+
+func (s *LogStore) List() []int {
+	return s.index.List()
+}
+
+func (s *LogStore) Len() int {
+	return s.index.Len()
+}
+
+/*
+MaxId returns the highest id ever stored in the log, including ids whose records have since been deleted.
+*/
+func (s *LogStore) MaxId() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.maxId
+}
+
+/*
+ReserveIds raises MaxId to at least maxId, by appending an entry recording the reservation.
+*/
+func (s *LogStore) ReserveIds(maxId int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if maxId <= s.maxId {
+		return nil
+	}
+	return s.append(logEntry{Op: logOpReserve, Id: maxId})
+}
+
+/*
+Close flushes the log to stable storage, closes it, and releases the lock on its directory.
+*/
+func (s *LogStore) Close() error {
+	s.compactMutex.Lock()
+	defer s.compactMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	close(s.done)
+	err := s.file.Sync()
+	closeErr := s.file.Close()
+	s.file = nil
+	s.lock.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+/*
+Compact rewrites the live records into a single segment, replacing all sealed segments.
+The active segment is sealed first, so that every entry written before the call is compacted.
+*/
+func (s *LogStore) Compact() error {
+	s.compactMutex.Lock()
+	defer s.compactMutex.Unlock()
+
+	s.mutex.Lock()
+	if s.file == nil {
+		s.mutex.Unlock()
+		return errors.New("log store is closed")
+	}
+	err := s.rotate()
+	if err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	base := s.segment - 1
+	ids := s.index.List()
+	records := make([]HashRecord, len(ids))
+	for i, id := range ids {
+		records[i], _ = s.index.Get(id)
+	}
+	maxId := s.maxId
+	s.mutex.Unlock()
+
+	tempPath := s.segmentPath(base) + compactTempSuffix
+	err = writeCompactedSegment(tempPath, maxId, ids, records)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err = os.Rename(tempPath, s.segmentPath(base))
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	s.entries[base] = len(ids) + 1
+	syncDir(s.dir)
+	return s.removeSegmentsBefore(base)
+}
+
+/*
+append writes entry to the end of the active segment, starting a new segment if it has grown
+too large. If the write fails, the segment is truncated back to its previous length so that a
+partial entry is not left behind. The caller must hold s.mutex.
+*/
+func (s *LogStore) append(entry logEntry) error {
+	if s.file == nil {
+		return errors.New("log store is closed")
+	}
+	buf, err := encodeLogEntry(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.WriteAt(buf, s.offset)
+	if err == nil && s.options.SyncPolicy == SyncAlways {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.file.Truncate(s.offset)
+		return err
+	}
+	s.offset += int64(len(buf))
+	s.entries[s.segment]++
+	s.dirty = true
+	if entry.Id > s.maxId {
+		s.maxId = entry.Id
+	}
+	if s.offset >= s.options.MaxSegmentSize {
+		return s.rotate()
+	}
+	return nil
+}
+
+/*
+rotate seals the active segment and starts a new one. The caller must hold s.mutex.
+*/
+func (s *LogStore) rotate() error {
+	err := s.file.Sync()
+	if err != nil {
+		return err
+	}
+	next, err := os.OpenFile(s.segmentPath(s.segment+1), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = next
+	s.segment++
+	s.offset = 0
+	s.dirty = false
+	syncDir(s.dir)
+	return nil
+}
+
+/*
+replay rebuilds the index from every segment in order, and opens the last segment for appending.
+*/
+func (s *LogStore) replay() error {
+	segments, err := s.listSegments()
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		segments = []int{1}
+	}
+	base := segments[0]
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		file, err := os.OpenFile(s.segmentPath(segment), os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		isBase, err := s.replaySegment(file, segment, last)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if isBase {
+			base = segment
+		}
+		if last {
+			s.file = file
+			s.segment = segment
+		} else {
+			file.Close()
+		}
+	}
+	return s.removeSegmentsBefore(base)
+}
+
+/*
+replaySegment applies the entries of one segment to the index, and reports whether the segment
+begins with a base entry. A torn or corrupt tail is truncated if the segment is the last one,
+and is an error otherwise.
+*/
+func (s *LogStore) replaySegment(file *os.File, segment int, last bool) (bool, error) {
+	reader := io.NewSectionReader(file, 0, 1<<62)
+	isBase := false
+	s.offset = 0
+	for {
+		entry, size, err := readLogEntry(reader)
+		if err == io.EOF {
+			return isBase, nil
+		}
+		if err == nil {
+			err = s.apply(entry)
+		}
+		if err != nil {
+			if !last {
+				return isBase, errors.New(fmt.Sprintf("corrupt log segment %s at offset %d: %s",
+					file.Name(), s.offset, err))
+			}
+			log.Printf("truncating log segment %s at offset %d: %s", file.Name(), s.offset, err)
+			return isBase, file.Truncate(s.offset)
+		}
+		if entry.Op == logOpBase && s.offset == 0 {
+			isBase = true
+			for id := range s.entries {
+				delete(s.entries, id)
+			}
+		}
+		s.offset += size
+		s.entries[segment]++
+	}
+}
+
+func (s *LogStore) apply(entry logEntry) error {
+	switch {
+	case entry.Op == logOpPut && entry.Record != nil:
+		s.index.Put(entry.Id, *entry.Record)
+	case entry.Op == logOpDelete:
+		s.index.Delete(entry.Id)
+	case entry.Op == logOpBase:
+		s.index = NewMemoryStore()
+	case entry.Op == logOpReserve:
+	default:
+		return errors.New(fmt.Sprintf("unknown operation %q", entry.Op))
+	}
+	if entry.Id > s.maxId {
+		s.maxId = entry.Id
+	}
+	return nil
+}
+
+/*
+removeSegmentsBefore removes every segment numbered below base, and any leftover compaction output.
+*/
+func (s *LogStore) removeSegmentsBefore(base int) error {
+	names, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name.Name(), compactTempSuffix) {
+			os.Remove(filepath.Join(s.dir, name.Name()))
+		}
+	}
+	segments, err := s.listSegments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment < base {
+			err = os.Remove(s.segmentPath(segment))
+			if err != nil {
+				return err
+			}
+			delete(s.entries, segment)
+		}
+	}
+	syncDir(s.dir)
+	return nil
+}
+
+func (s *LogStore) listSegments() ([]int, error) {
+	names, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := []int{}
+	for _, name := range names {
+		number, ok := strings.CutPrefix(name.Name(), segmentPrefix)
+		if !ok {
+			continue
+		}
+		number, ok = strings.CutSuffix(number, segmentSuffix)
+		if !ok {
+			continue
+		}
+		segment, err := strconv.Atoi(number)
+		if err == nil {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func (s *LogStore) segmentPath(segment int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%016d%s", segmentPrefix, segment, segmentSuffix))
+}
+
+/*
+garbage returns the number of entries in the log that have been superseded by later entries.
+The caller must hold s.mutex.
+*/
+func (s *LogStore) garbage() int {
+	total := 0
+	for _, count := range s.entries {
+		total += count
+	}
+	return total - s.index.Len()
+}
+
+func (s *LogStore) syncPeriodically() {
+	ticker := time.NewTicker(s.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			if s.dirty && s.file != nil {
+				err := s.file.Sync()
+				if err != nil {
+					log.Println(err)
+				}
+				s.dirty = false
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+func (s *LogStore) compactPeriodically() {
+	ticker := time.NewTicker(s.options.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mutex.Lock()
+			garbage := s.garbage()
+			live := s.index.Len()
+			deleted := s.deleted
+			s.deleted = false
+			s.mutex.Unlock()
+			if deleted || (garbage >= minCompactGarbage && garbage >= live) {
+				err := s.Compact()
+				if err != nil {
+					log.Println(err)
+					s.mutex.Lock()
+					s.deleted = s.deleted || deleted
+					s.mutex.Unlock()
+				}
+			}
+		}
+	}
+}
+
+func writeCompactedSegment(path string, maxId int, ids []int, records []HashRecord) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buf, err := encodeLogEntry(logEntry{Op: logOpBase, Id: maxId})
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf)
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		buf, err = encodeLogEntry(logEntry{logOpPut, id, &records[i]})
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+func encodeLogEntry(entry logEntry) ([]byte, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, logHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(payload, logChecksumTable))
+	copy(buf[logHeaderSize:], payload)
+	return buf, nil
+}
+
+/*
+readLogEntry reads and verifies one entry, returning it and its size in bytes.
+It returns io.EOF only if reader is exactly at the end of the last entry.
+*/
+func readLogEntry(reader io.Reader) (logEntry, int64, error) {
+	entry := logEntry{}
+	header := make([]byte, logHeaderSize)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return entry, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxLogEntrySize {
+		return entry, 0, errors.New(fmt.Sprintf("entry length %d exceeds limit", length))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		return entry, 0, err
+	}
+	if crc32.Checksum(payload, logChecksumTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return entry, 0, errors.New("checksum mismatch")
+	}
+	err = json.Unmarshal(payload, &entry)
+	return entry, int64(logHeaderSize + length), err
+}
+
+/*
+syncDir flushes directory entries, so that created, renamed and removed segments survive a crash.
+*/
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,242 @@
+package model_test
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+	"github.com/ifIMust/encodeServer/server/model/storetest"
+)
+
+func openLogStore(t *testing.T, dir string, options model.LogStoreOptions) *model.LogStore {
+	s, err := model.OpenLogStore(dir, options)
+	if err != nil {
+		t.Fatalf("Failed to open log store: %s", err)
+	}
+	return s
+}
+
+func segments(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	if err != nil {
+		t.Fatalf("Failed to list segments: %s", err)
+	}
+	return names
+}
+
+func TestLogStore(t *testing.T) {
+	storetest.TestHashStore(t, func(t *testing.T) model.HashStore {
+		s := openLogStore(t, t.TempDir(), model.LogStoreOptions{})
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestLogStoreReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStore(t, dir, model.LogStoreOptions{})
+	s.Put(1, model.HashRecord{Algorithm: "sha256", Hash: []byte{1}})
+	s.Put(2, model.HashRecord{Algorithm: "sha256", Hash: []byte{2}})
+	s.Put(1, model.HashRecord{Algorithm: "sha512", Hash: []byte{3}})
+	s.Put(3, model.HashRecord{Algorithm: "sha256", Hash: []byte{4}})
+	s.Delete(3)
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC)
+	publicId := "0170a1b2-c3d4-7e5f-8a6b-000000000004"
+	s.Put(4, model.HashRecord{Algorithm: "sha256", Hash: []byte{5}, Expires: expires, PublicId: publicId})
+	s.Close()
+
+	s = openLogStore(t, dir, model.LogStoreOptions{})
+	defer s.Close()
+	if s.Len() != 3 {
+		t.Errorf("Expected %d records, had %d", 3, s.Len())
+	}
+	record, _ := s.Get(1)
+	if record.Algorithm != "sha512" || record.Hash[0] != 3 {
+		t.Errorf("Expected replaced record, got %v", record)
+	}
+	record, _ = s.Get(4)
+	if !record.Expires.Equal(expires) || record.PublicId != publicId {
+		t.Errorf("Expected expiry and public id to be replayed, got %v", record)
+	}
+	if _, ok := s.Get(3); ok {
+		t.Errorf("Deleted record was replayed")
+	}
+	if s.MaxId() != 4 {
+		t.Errorf("Expected max id %d, had %d", 4, s.MaxId())
+	}
+}
+
+func TestLogStoreLocksDirectory(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStore(t, dir, model.LogStoreOptions{})
+	if _, err := model.OpenLogStore(dir, model.LogStoreOptions{}); err != model.ErrLogLocked {
+		t.Errorf("Expected %v opening a log in use, got %v", model.ErrLogLocked, err)
+	}
+	s.Close()
+	s = openLogStore(t, dir, model.LogStoreOptions{})
+	s.Close()
+}
+
+func TestLogStoreTruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStore(t, dir, model.LogStoreOptions{})
+	s.Put(1, model.HashRecord{Algorithm: "sha256", Hash: []byte{1}})
+	s.Put(2, model.HashRecord{Algorithm: "sha256", Hash: []byte{2}})
+	s.Close()
+	path := segments(t, dir)[0]
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-3)
+
+	s = openLogStore(t, dir, model.LogStoreOptions{})
+	if s.Len() != 1 {
+		t.Errorf("Expected %d records, had %d", 1, s.Len())
+	}
+	s.Put(3, model.HashRecord{Algorithm: "sha256", Hash: []byte{3}})
+	s.Close()
+
+	s = openLogStore(t, dir, model.LogStoreOptions{})
+	defer s.Close()
+	if _, ok := s.Get(3); !ok || s.Len() != 2 {
+		t.Errorf("Expected records appended after recovery to be replayed, had %v", s.List())
+	}
+}
+
+func TestLogStoreTruncatesCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStore(t, dir, model.LogStoreOptions{})
+	s.Put(1, model.HashRecord{Algorithm: "sha256", Hash: []byte{1}})
+	s.Put(2, model.HashRecord{Algorithm: "sha256", Hash: []byte{2}})
+	s.Close()
+	path := segments(t, dir)[0]
+	data, _ := os.ReadFile(path)
+	data[len(data)-2] ^= 0xff
+	os.WriteFile(path, data, 0600)
+
+	s = openLogStore(t, dir, model.LogStoreOptions{})
+	defer s.Close()
+	if _, ok := s.Get(2); ok || s.Len() != 1 {
+		t.Errorf("Expected corrupt record to be discarded, had %v", s.List())
+	}
+}
+
+func TestLogStoreRotatesSegments(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStore(t, dir, model.LogStoreOptions{MaxSegmentSize: 100})
+	for id := 1; id <= 10; id++ {
+		s.Put(id, model.HashRecord{Algorithm: "sha256", Hash: []byte{byte(id)}})
+	}
+	s.Close()
+	if len(segments(t, dir)) < 5 {
+		t.Errorf("Expected segments to rotate, had %v", segments(t, dir))
+	}
+	s = openLogStore(t, dir, model.LogStoreOptions{MaxSegmentSize: 100})
+	defer s.Close()
+	if s.Len() != 10 {
+		t.Errorf("Expected %d records, had %d", 10, s.Len())
+	}
+}
+
+func TestLogStoreCompact(t *testing.T) {
+	dir := t.TempDir()
+	options := model.LogStoreOptions{MaxSegmentSize: 300}
+	s := openLogStore(t, dir, options)
+	for i := 0; i < 5; i++ {
+		for id := 1; id <= 4; id++ {
+			s.Put(id, model.HashRecord{Algorithm: "sha256", Hash: []byte{byte(i)}})
+		}
+	}
+	s.Delete(4)
+	before := len(segments(t, dir))
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact produced error %s", err)
+	}
+	s.Put(5, model.HashRecord{Algorithm: "sha256", Hash: []byte{5}})
+	after := len(segments(t, dir))
+	if after != 2 || after >= before {
+		t.Errorf("Expected compaction to leave 2 segments, had %d before and %d after", before, after)
+	}
+	record, _ := s.Get(1)
+	if s.Len() != 4 || record.Hash[0] != 4 {
+		t.Errorf("Compaction changed the index: %v %v", s.List(), record)
+	}
+	s.Close()
+
+	s = openLogStore(t, dir, options)
+	defer s.Close()
+	record, _ = s.Get(1)
+	if s.Len() != 4 || record.Hash[0] != 4 {
+		t.Errorf("Expected compacted log to replay, had %v %v", s.List(), record)
+	}
+	if _, ok := s.Get(4); ok {
+		t.Errorf("Deleted record was replayed from compacted log")
+	}
+	if s.MaxId() != 5 {
+		t.Errorf("Expected max id %d, had %d", 5, s.MaxId())
+	}
+}
+
+func TestLogStoreErasesDeletedRecords(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStore(t, dir, model.LogStoreOptions{CompactInterval: time.Millisecond})
+	defer s.Close()
+	s.Put(1, model.HashRecord{Algorithm: "sha256", Hash: []byte("erase me")})
+	s.Put(2, model.HashRecord{Algorithm: "sha256", Hash: []byte{2}})
+	s.Delete(1)
+	encoded := base64.StdEncoding.EncodeToString([]byte("erase me"))
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		found := false
+		for _, name := range segments(t, dir) {
+			data, _ := os.ReadFile(name)
+			found = found || strings.Contains(string(data), encoded)
+		}
+		if !found {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("Deleted record was not erased from the log")
+}
+
+func TestLogStoreRecoversInterruptedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := openLogStore(t, dir, model.LogStoreOptions{})
+	s.Put(1, model.HashRecord{Algorithm: "sha256", Hash: []byte{1}})
+	s.Put(2, model.HashRecord{Algorithm: "sha256", Hash: []byte{2}})
+	precompacted, _ := os.ReadFile(segments(t, dir)[0])
+	s.Delete(2)
+	s.Compact()
+	s.Close()
+	// Simulate a crash after the compacted segment was renamed into place,
+	// but before the segments it replaced were removed.
+	old := filepath.Join(dir, "segment-0000000000000000.log")
+	stale := filepath.Join(dir, "segment-0000000000000001.log.compact")
+	os.WriteFile(old, precompacted, 0600)
+	os.WriteFile(stale, []byte("partial"), 0600)
+
+	s = openLogStore(t, dir, model.LogStoreOptions{})
+	defer s.Close()
+	if _, ok := s.Get(2); ok || s.Len() != 1 {
+		t.Errorf("Expected only live records after recovery, had %v", s.List())
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected leftover compaction output to be removed")
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected segments older than the compacted segment to be removed")
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	policy, err := model.ParseSyncPolicy("periodic")
+	if err != nil || policy != model.SyncPeriodic {
+		t.Errorf("Expected SyncPeriodic, got %v (%v)", policy, err)
+	}
+	if _, err := model.ParseSyncPolicy("sometimes"); err == nil {
+		t.Errorf("Expected error for unknown sync policy")
+	}
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultShardCount is the number of shards used by NewMemoryStore.
+const DefaultShardCount = 64
+
+/*
+A MemoryStore is a threadsafe HashStore that keeps its records in maps.
+The records are divided by id among a fixed number of shards, each with its own lock,
+so that records with different ids can be stored and retrieved concurrently.
+Its records last only as long as the process.
+*/
+type MemoryStore struct {
+	shards []memoryShard
+	count  atomic.Int64
+}
+
+type memoryShard struct {
+	records map[int]HashRecord
+	mutex   sync.RWMutex
+}
+
+/*
+NewMemoryStore returns an empty MemoryStore with DefaultShardCount shards.
+*/
+func NewMemoryStore() *MemoryStore {
+	return NewShardedMemoryStore(DefaultShardCount)
+}
+
+/*
+NewShardedMemoryStore returns an empty MemoryStore with the given number of shards, or one shard
+if shards is less than 1.
+*/
+func NewShardedMemoryStore(shards int) *MemoryStore {
+	if shards < 1 {
+		shards = 1
+	}
+	s := new(MemoryStore)
+	s.shards = make([]memoryShard, shards)
+	for i := range s.shards {
+		s.shards[i].records = make(map[int]HashRecord)
+	}
+	return s
+}
+
+func (s *MemoryStore) shard(id int) *memoryShard {
+	return &s.shards[uint(id)%uint(len(s.shards))]
+}
+
+func (s *MemoryStore) Put(id int, record HashRecord) error {
+	shard := s.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if _, ok := shard.records[id]; !ok {
+		s.count.Add(1)
+	}
+	shard.records[id] = record
+	return nil
+}
+
+func (s *MemoryStore) Get(id int) (HashRecord, bool) {
+	shard := s.shard(id)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+	record, ok := shard.records[id]
+	return record, ok
+}
+
+func (s *MemoryStore) Delete(id int) error {
+	shard := s.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if _, ok := shard.records[id]; ok {
+		s.count.Add(-1)
+		delete(shard.records, id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) List() []int {
+	ids := make([]int, 0, s.Len())
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.RLock()
+		for id := range shard.records {
+			ids = append(ids, id)
+		}
+		shard.mutex.RUnlock()
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (s *MemoryStore) Len() int {
+	return int(s.count.Load())
+}
@@ -0,0 +1,53 @@
+package model_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ifIMust/encodeServer/server/model"
+	"github.com/ifIMust/encodeServer/server/model/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.TestHashStore(t, func(t *testing.T) model.HashStore {
+		return model.NewMemoryStore()
+	})
+}
+
+func TestShardedMemoryStore(t *testing.T) {
+	storetest.TestHashStore(t, func(t *testing.T) model.HashStore {
+		return model.NewShardedMemoryStore(3)
+	})
+}
+
+func benchmarkMemoryStore(b *testing.B, shards int) {
+	s := model.NewShardedMemoryStore(shards)
+	record := model.HashRecord{Algorithm: "sha512", Hash: make([]byte, 64)}
+	for id := 1; id <= 10000; id++ {
+		s.Put(id, record)
+	}
+	var nextId atomic.Int64
+	nextId.Store(10000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%4 == 0 {
+				s.Put(int(nextId.Add(1)), record)
+			} else {
+				s.Get(i%10000 + 1)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMemoryStore(b *testing.B) {
+	b.Run("shards=1", func(b *testing.B) {
+		benchmarkMemoryStore(b, 1)
+	})
+	b.Run(fmt.Sprintf("shards=%d", model.DefaultShardCount), func(b *testing.B) {
+		benchmarkMemoryStore(b, model.DefaultShardCount)
+	})
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// SnapshotVersion is the version of the snapshot format written by WriteSnapshot.
+	SnapshotVersion = 1
+)
+
+/*
+A SnapshotHeader is the first line of a snapshot. LastId is the highest id that had been assigned
+when the snapshot was taken, whether or not a record is stored under it, so that a restored
+server does not reuse ids. Count is the number of records that follow the header.
+*/
+type SnapshotHeader struct {
+	Version int       `json:"version"`
+	LastId  int       `json:"last_id"`
+	Taken   time.Time `json:"taken"`
+	Count   int       `json:"count"`
+}
+
+/*
+A SnapshotRecord is one line of a snapshot following the header.
+*/
+type SnapshotRecord struct {
+	Id     int        `json:"id"`
+	Record HashRecord `json:"record"`
+}
+
+/*
+WriteSnapshot writes a snapshot in JSON lines format: a SnapshotHeader on the first line,
+followed by a SnapshotRecord on each line for every id in ids and its corresponding record.
+*/
+func WriteSnapshot(w io.Writer, lastId int, ids []int, records []HashRecord) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	err := encoder.Encode(SnapshotHeader{SnapshotVersion, lastId, time.Now().UTC(), len(ids)})
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		err = encoder.Encode(SnapshotRecord{id, records[i]})
+		if err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+/*
+ReadSnapshot reads a snapshot written by WriteSnapshot, calling restore for each record in turn.
+It returns the snapshot's header, with LastId raised if necessary to cover every restored id.
+*/
+func ReadSnapshot(r io.Reader, restore func(id int, record HashRecord) error) (SnapshotHeader, error) {
+	decoder := json.NewDecoder(r)
+	header := SnapshotHeader{}
+	err := decoder.Decode(&header)
+	if err != nil {
+		return header, errors.New(fmt.Sprintf("malformed snapshot header: %s", err))
+	}
+	if header.Version != SnapshotVersion {
+		return header, errors.New(fmt.Sprintf("unsupported snapshot version: %d", header.Version))
+	}
+	count := 0
+	for {
+		entry := SnapshotRecord{}
+		err = decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return header, errors.New(fmt.Sprintf("malformed snapshot record %d: %s", count+1, err))
+		}
+		if entry.Id < 1 || entry.Record.Algorithm == "" || len(entry.Record.Hash) == 0 {
+			return header, errors.New(fmt.Sprintf("invalid snapshot record %d: id %d", count+1, entry.Id))
+		}
+		err = restore(entry.Id, entry.Record)
+		if err != nil {
+			return header, err
+		}
+		if entry.Id > header.LastId {
+			header.LastId = entry.Id
+		}
+		count++
+	}
+	if count != header.Count {
+		return header, errors.New(fmt.Sprintf("truncated snapshot: expected %d records, read %d", header.Count, count))
+	}
+	return header, nil
+}
+
+/*
+SnapshotStore writes a snapshot of every record in store. It does not prevent concurrent
+changes to store, so it should only be used on a store that is not in use.
+*/
+func SnapshotStore(w io.Writer, store HashStore) error {
+	ids := store.List()
+	records := make([]HashRecord, 0, len(ids))
+	present := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if record, ok := Peek(store, id); ok {
+			present = append(present, id)
+			records = append(records, record)
+		}
+	}
+	return WriteSnapshot(w, LastId(store), present, records)
+}
+
+/*
+RestoreStore loads a snapshot into store, which must be empty.
+If store is an IdTracker, it reserves every id up to the snapshot's LastId.
+*/
+func RestoreStore(r io.Reader, store HashStore) (SnapshotHeader, error) {
+	if store.Len() != 0 {
+		return SnapshotHeader{}, errors.New("cannot restore a snapshot into a store that is not empty")
+	}
+	header, err := ReadSnapshot(r, store.Put)
+	if err != nil {
+		return header, err
+	}
+	if tracker, ok := store.(IdTracker); ok {
+		err = tracker.ReserveIds(header.LastId)
+	}
+	return header, err
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	source := NewMemoryStore()
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC)
+	source.Put(3, HashRecord{Algorithm: "pbkdf2-sha512", Hash: []byte{3}, Salt: []byte{4}, Iterations: 5, KeyId: "k",
+		Expires: expires, PublicId: "0170a1b2-c3d4-7e5f-8a6b-000000000003"})
+	source.Put(7, HashRecord{Algorithm: "sha256", Hash: []byte{7}})
+	buf := new(bytes.Buffer)
+	if err := SnapshotStore(buf, source); err != nil {
+		t.Fatalf("Snapshot produced error %s", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 3 {
+		t.Errorf("Expected %d lines, got %d", 3, lines)
+	}
+
+	target, err := OpenLogStore(filepath.Join(t.TempDir(), "log"), LogStoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed to open log store: %s", err)
+	}
+	defer target.Close()
+	header, err := RestoreStore(buf, target)
+	if err != nil {
+		t.Fatalf("Restore produced error %s", err)
+	}
+	if header.Count != 2 || header.LastId != 7 || target.MaxId() != 7 {
+		t.Errorf("Unexpected header %v, max id %d", header, target.MaxId())
+	}
+	record, _ := target.Get(3)
+	if record.KeyId != "k" || record.Iterations != 5 || record.Salt[0] != 4 || !record.Expires.Equal(expires) ||
+		record.PublicId != "0170a1b2-c3d4-7e5f-8a6b-000000000003" {
+		t.Errorf("Record was not restored intact: %v", record)
+	}
+}
+
+func TestRestorePreservesLastId(t *testing.T) {
+	buf := new(bytes.Buffer)
+	WriteSnapshot(buf, 10, []int{2}, []HashRecord{{Algorithm: "sha256", Hash: []byte{2}}})
+	target, _ := OpenLogStore(filepath.Join(t.TempDir(), "log"), LogStoreOptions{})
+	defer target.Close()
+	RestoreStore(buf, target)
+	if target.MaxId() != 10 {
+		t.Errorf("Expected max id %d, had %d", 10, target.MaxId())
+	}
+}
+
+func TestRestoreRejectsBadSnapshots(t *testing.T) {
+	snapshots := []string{
+		"",
+		`{"version":2,"last_id":0,"count":0}`,
+		`{"version":1,"last_id":1,"count":2}` + "\n" + `{"id":1,"record":{"Algorithm":"sha256","Hash":"AQ=="}}`,
+		`{"version":1,"last_id":1,"count":1}` + "\n" + `{"id":0,"record":{"Algorithm":"sha256","Hash":"AQ=="}}`,
+	}
+	for _, snapshot := range snapshots {
+		if _, err := RestoreStore(strings.NewReader(snapshot), NewMemoryStore()); err == nil {
+			t.Errorf("Expected error restoring %q", snapshot)
+		}
+	}
+	store := NewMemoryStore()
+	store.Put(1, HashRecord{Algorithm: "sha256", Hash: []byte{1}})
+	if _, err := RestoreStore(strings.NewReader(`{"version":1,"last_id":0,"count":0}`), store); err == nil {
+		t.Errorf("Expected error restoring into a store that is not empty")
+	}
+}
@@ -12,19 +12,27 @@ import (
 
 /*
 A Stats is a threadsafe tracker of total requests and average processing time.
+Password verifications are counted separately from hash requests, as are hashes removed
+because their time-to-live elapsed, and hashes evicted to keep the store within its limits.
 */
 type Stats struct {
-	requests  int
-	totalTime time.Duration
-	mutex     sync.Mutex
+	requests      int
+	totalTime     time.Duration
+	verifications int
+	expirations   int
+	evictions     int
+	mutex         sync.Mutex
 }
 
 /*
 A StatsReport is used for marshaling statistical output to JSON.
 */
 type StatsReport struct {
-	Total   int
-	Average int
+	Total         int
+	Average       int
+	Verifications int
+	Expired       int
+	Evicted       int
 }
 
 func NewStats() *Stats {
@@ -38,6 +46,24 @@ func (s *Stats) AddRequest(t time.Duration) {
 	s.totalTime += t
 }
 
+func (s *Stats) AddVerification() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.verifications++
+}
+
+func (s *Stats) AddExpirations(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expirations += n
+}
+
+func (s *Stats) AddEviction() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.evictions++
+}
+
 func (s *Stats) GetStats() StatsReport {
 	averageTime := time.Duration(0)
 	s.mutex.Lock()
@@ -45,7 +71,7 @@ func (s *Stats) GetStats() StatsReport {
 	if s.requests != 0 {
 		averageTime = s.totalTime / time.Duration(s.requests)
 	}
-	return StatsReport{s.requests, int(averageTime)}
+	return StatsReport{s.requests, int(averageTime), s.verifications, s.expirations, s.evictions}
 }
 
 func (s *Stats) GetStatsJson() string {
@@ -17,7 +17,7 @@ func TestGetStatsZero(t *testing.T) {
 func TestGetStatsJson(t *testing.T) {
 	s := NewStats()
 	statsJson := s.GetStatsJson()
-	expected := "{\"total\":0,\"average\":0}"
+	expected := "{\"total\":0,\"average\":0,\"verifications\":0,\"expired\":0,\"evicted\":0}"
 	if statsJson != expected {
 		t.Errorf("Bad outputs. Expected '%s' got '%s'", expected, statsJson)
 	}
@@ -36,3 +36,26 @@ func TestGetStatsTwoRequests(t *testing.T) {
 			0, report.Average)
 	}
 }
+
+func TestGetStatsVerifications(t *testing.T) {
+	s := NewStats()
+	s.AddRequest(100)
+	s.AddVerification()
+	s.AddVerification()
+	report := s.GetStats()
+	if report.Total != 1 {
+		t.Errorf("Expected report.requests to be %d, was %d", 1, report.Total)
+	}
+	if report.Verifications != 2 {
+		t.Errorf("Expected report.Verifications to be %d, was %d", 2, report.Verifications)
+	}
+}
+
+func TestGetStatsExpirations(t *testing.T) {
+	s := NewStats()
+	s.AddExpirations(3)
+	s.AddExpirations(2)
+	if report := s.GetStats(); report.Expired != 5 {
+		t.Errorf("Expected report.Expired to be %d, was %d", 5, report.Expired)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+Package storetest provides a contract test suite for implementations of model.HashStore.
+*/
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
+)
+
+/*
+TestHashStore runs the model.HashStore contract tests against stores created by newStore.
+Each test calls newStore once, and expects the store it returns to be empty.
+*/
+func TestHashStore(t *testing.T, newStore func(t *testing.T) model.HashStore) {
+	t.Run("Empty", func(t *testing.T) { testEmpty(t, newStore(t)) })
+	t.Run("PutGet", func(t *testing.T) { testPutGet(t, newStore(t)) })
+	t.Run("Replace", func(t *testing.T) { testReplace(t, newStore(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStore(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, newStore(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, newStore(t)) })
+}
+
+func newRecord(n int) model.HashRecord {
+	return model.HashRecord{
+		Algorithm:  "pbkdf2-sha512",
+		Hash:       []byte(fmt.Sprintf("hash %d", n)),
+		Salt:       []byte(fmt.Sprintf("salt %d", n)),
+		Iterations: n,
+		KeyId:      "key",
+		Created:    time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Completed:  time.Date(2020, 1, 2, 3, 4, 10, n, time.UTC),
+		Expires:    time.Date(2020, 1, 3, 3, 4, 10, n, time.UTC),
+		PublicId:   fmt.Sprintf("0170a1b2-c3d4-7e5f-8a6b-%012d", n),
+	}
+}
+
+func equal(a model.HashRecord, b model.HashRecord) bool {
+	return a.Algorithm == b.Algorithm && bytes.Equal(a.Hash, b.Hash) && bytes.Equal(a.Salt, b.Salt) &&
+		a.Iterations == b.Iterations && a.KeyId == b.KeyId &&
+		a.Created.Equal(b.Created) && a.Completed.Equal(b.Completed) &&
+		a.Expires.Equal(b.Expires) && a.PublicId == b.PublicId
+}
+
+func testEmpty(t *testing.T, s model.HashStore) {
+	if s.Len() != 0 || len(s.List()) != 0 {
+		t.Errorf("Expected empty store, had %d records", s.Len())
+	}
+	if _, ok := s.Get(1); ok {
+		t.Errorf("Expected missing record")
+	}
+	if err := s.Delete(1); err != nil {
+		t.Errorf("Deleting a missing record produced error %s", err)
+	}
+}
+
+func testPutGet(t *testing.T, s model.HashStore) {
+	record := newRecord(1)
+	if err := s.Put(7, record); err != nil {
+		t.Fatalf("Put produced error %s", err)
+	}
+	retrieved, ok := s.Get(7)
+	if !ok || !equal(record, retrieved) {
+		t.Errorf("Expected %v, got %v (%v)", record, retrieved, ok)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Expected %d records, had %d", 1, s.Len())
+	}
+}
+
+func testReplace(t *testing.T, s model.HashStore) {
+	s.Put(7, newRecord(1))
+	s.Put(7, newRecord(2))
+	retrieved, _ := s.Get(7)
+	if !equal(newRecord(2), retrieved) {
+		t.Errorf("Expected %v, got %v", newRecord(2), retrieved)
+	}
+	if s.Len() != 1 {
+		t.Errorf("Expected %d records, had %d", 1, s.Len())
+	}
+}
+
+func testDelete(t *testing.T, s model.HashStore) {
+	s.Put(7, newRecord(1))
+	s.Put(8, newRecord(2))
+	if err := s.Delete(7); err != nil {
+		t.Fatalf("Delete produced error %s", err)
+	}
+	if _, ok := s.Get(7); ok {
+		t.Errorf("Deleted record was retrieved")
+	}
+	if _, ok := s.Get(8); !ok {
+		t.Errorf("Record was lost when another was deleted")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Expected %d records, had %d", 1, s.Len())
+	}
+}
+
+func testList(t *testing.T, s model.HashStore) {
+	for _, id := range []int{5, 3, 9, 1} {
+		s.Put(id, newRecord(id))
+	}
+	s.Delete(9)
+	ids := s.List()
+	expected := []int{1, 3, 5}
+	if fmt.Sprint(ids) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, ids)
+	}
+}
+
+func testConcurrent(t *testing.T, s model.HashStore) {
+	workers := 8
+	perWorker := 50
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := w*perWorker + i + 1
+				s.Put(id, newRecord(id))
+				s.Get(id)
+				s.List()
+			}
+		}(w)
+	}
+	wg.Wait()
+	if s.Len() != workers*perWorker {
+		t.Errorf("Expected %d records, had %d", workers*perWorker, s.Len())
+	}
+}
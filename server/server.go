@@ -5,6 +5,8 @@ package server
 
 import (
 	"context"
+	"io"
+	"log"
 	"net/http"
 	"sync"
 	"time"
@@ -22,17 +24,33 @@ type Server struct {
 	ShutdownComplete chan int
 	server           *http.Server
 	hashHandler      *handler.HashHandler
+	digestHandler    *handler.DigestHandler
+	adminHandler     *handler.AdminHandler
+	store            model.HashStore
 }
 
-func NewServer(port string) *Server {
+/*
+NewServer initializes and returns a new Server that will listen on port, and keep hashes in store.
+If store is an io.Closer, it is closed when the server shuts down.
+*/
+func NewServer(port string, store model.HashStore) *Server {
 	s := new(Server)
 	s.ShutdownComplete = make(chan int)
+	s.store = store
 	shutdownWaitGroup := new(sync.WaitGroup)
 	mux := http.NewServeMux()
 	stats := model.NewStats()
-	s.hashHandler = handler.NewHashHandler(stats, shutdownWaitGroup)
+	s.hashHandler = handler.NewHashHandler(stats, store, shutdownWaitGroup)
 	statsHandler := handler.NewStatsHandler(stats)
-	handlers := []handler.Shutdowner{s.hashHandler, statsHandler}
+	verifyHandler := handler.NewVerifyHandler(s.hashHandler, stats)
+	s.digestHandler = handler.NewDigestHandler(s.hashHandler, stats)
+	batchHandler := handler.NewBatchHandler(s.hashHandler, stats)
+	s.adminHandler = handler.NewAdminHandler(s.hashHandler)
+	eventsHandler := handler.NewEventsHandler(s.hashHandler)
+	socketHandler := handler.NewSocketHandler(s.hashHandler, stats)
+	s.hashHandler.SetAdminAuthorizer(s.adminHandler.IsAuthorized)
+	handlers := []handler.Shutdowner{s.hashHandler, statsHandler, verifyHandler, s.digestHandler, batchHandler,
+		s.adminHandler, eventsHandler, socketHandler}
 	killFunc := func() {
 		s.shutdown()
 	}
@@ -40,6 +58,12 @@ func NewServer(port string) *Server {
 
 	mux.HandleFunc("/hash", getWrappedHandler(s.hashHandler.HandleRequest, shutdownWaitGroup))
 	mux.HandleFunc("/hash/", getWrappedHandler(s.hashHandler.HandleRequest, shutdownWaitGroup))
+	mux.HandleFunc("/admin/snapshot", getWrappedHandler(s.adminHandler.HandleRequest, shutdownWaitGroup))
+	mux.HandleFunc("/batch", getWrappedHandler(batchHandler.HandleRequest, shutdownWaitGroup))
+	mux.HandleFunc("/events", getWrappedHandler(eventsHandler.HandleRequest, shutdownWaitGroup))
+	mux.HandleFunc("/ws", getWrappedHandler(socketHandler.HandleRequest, shutdownWaitGroup))
+	mux.HandleFunc("/digest", getWrappedHandler(s.digestHandler.HandleRequest, shutdownWaitGroup))
+	mux.HandleFunc("/verify", getWrappedHandler(verifyHandler.HandleRequest, shutdownWaitGroup))
 	mux.HandleFunc("/stats", getWrappedHandler(statsHandler.HandleRequest, shutdownWaitGroup))
 	mux.HandleFunc("/shutdown", shutdownHandler.HandleRequest)
 
@@ -68,8 +92,92 @@ func (s *Server) SetDelay(delay time.Duration) {
 	s.hashHandler.SetDelay(delay)
 }
 
+/*
+SetIdMode selects the kind of id issued for new hashes: handler.IdModeInteger, the default,
+or handler.IdModeUUID.
+*/
+func (s *Server) SetIdMode(mode string) error {
+	return s.hashHandler.SetIdMode(mode)
+}
+
+/*
+SetTTL modifies how long hashes are kept once stored, for requests that do not specify a
+time-to-live. The default of 0 keeps hashes indefinitely.
+*/
+func (s *Server) SetTTL(ttl time.Duration) error {
+	return s.hashHandler.SetTTL(ttl)
+}
+
+/*
+SetSweepInterval modifies how often expired hashes are deleted.
+The default interval is handler.DefaultSweepInterval.
+*/
+func (s *Server) SetSweepInterval(interval time.Duration) error {
+	return s.hashHandler.SetSweepInterval(interval)
+}
+
+/*
+SetDefaultAlgorithm selects the hash algorithm used for requests that do not specify one.
+The default algorithm is handler.DefaultAlgorithm.
+*/
+func (s *Server) SetDefaultAlgorithm(algorithm string) error {
+	return s.hashHandler.SetDefaultAlgorithm(algorithm)
+}
+
+/*
+SetIterations modifies the iteration count used by salted hash algorithms.
+The default iteration count is handler.DefaultIterations.
+*/
+func (s *Server) SetIterations(iterations int) error {
+	return s.hashHandler.SetIterations(iterations)
+}
+
+/*
+SetKeyring enables peppered hashing using the keys in keyring. By default, hashes are not peppered.
+*/
+func (s *Server) SetKeyring(keyring *handler.Keyring) {
+	s.hashHandler.SetKeyring(keyring)
+}
+
+/*
+SetMaxDigestSize modifies the limit, in bytes, on the size of a payload sent to '/digest'.
+The default limit is handler.DefaultMaxDigestSize.
+*/
+func (s *Server) SetMaxDigestSize(maxSize int64) error {
+	return s.digestHandler.SetMaxSize(maxSize)
+}
+
+/*
+SetAdminToken sets the bearer token that authorizes requests to the '/admin/' endpoints.
+By default there is no token, and all admin requests are refused.
+*/
+func (s *Server) SetAdminToken(token string) {
+	s.adminHandler.SetToken(token)
+}
+
+/*
+SetWebhookSecret sets the key with which completion webhooks are signed. By default there is no
+key, and requests with a callback URL are refused.
+*/
+func (s *Server) SetWebhookSecret(secret string) {
+	s.hashHandler.SetWebhookSecret(secret)
+}
+
+/*
+SetWebhookAllowPrivate selects whether completion webhooks may be sent to addresses that are not
+publicly routable, such as loopback and private network addresses. By default they may not.
+*/
+func (s *Server) SetWebhookAllowPrivate(allow bool) {
+	s.hashHandler.SetWebhookAllowPrivate(allow)
+}
+
 func (s *Server) shutdown() error {
 	err := s.server.Shutdown(context.Background())
+	if closer, ok := s.store.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil {
+			log.Println(closeErr)
+		}
+	}
 	s.ShutdownComplete <- 1
 	return err
 }
@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"testing"
 	"time"
+
+	"github.com/ifIMust/encodeServer/server/model"
 )
 
 const (
@@ -62,6 +64,19 @@ func doPost(t *testing.T) int {
 	return result
 }
 
+func doVerify(t *testing.T, id int, pwd string) string {
+	resp, err := http.PostForm("http://"+host+":"+port+"/verify",
+		url.Values{"id": {strconv.Itoa(id)}, "password": {pwd}})
+	if err != nil {
+		t.Errorf("Verify produced error %s", err)
+		return ""
+	}
+	body := make([]byte, 128)
+	n, _ := resp.Body.Read(body)
+	resp.Body.Close()
+	return string(body[0:n])
+}
+
 func doStats(t *testing.T) string {
 	resp, err := http.Get("http://" + host + ":" + port + "/stats")
 	if err != nil {
@@ -89,7 +104,7 @@ func doShutdown() {
 }
 
 func TestHandleShutdown(t *testing.T) {
-	s := NewServer(port)
+	s := NewServer(port, model.NewMemoryStore())
 	go s.Run()
 	time.Sleep(serverStartDelay)
 	doShutdown()
@@ -97,7 +112,7 @@ func TestHandleShutdown(t *testing.T) {
 }
 
 func TestHandleDoubleShutdown(t *testing.T) {
-	s := NewServer(port)
+	s := NewServer(port, model.NewMemoryStore())
 	go s.Run()
 	time.Sleep(serverStartDelay)
 	doShutdown()
@@ -106,7 +121,7 @@ func TestHandleDoubleShutdown(t *testing.T) {
 }
 
 func TestHandlePostRequest(t *testing.T) {
-	s := NewServer(port)
+	s := NewServer(port, model.NewMemoryStore())
 	s.SetDelay(1 * time.Microsecond)
 	go s.Run()
 	time.Sleep(serverStartDelay)
@@ -119,7 +134,7 @@ func TestHandlePostRequest(t *testing.T) {
 }
 
 func TestHandleDoubleShutdownWhileProcessing(t *testing.T) {
-	s := NewServer(port)
+	s := NewServer(port, model.NewMemoryStore())
 	s.SetDelay(1 * time.Microsecond)
 	go s.Run()
 	time.Sleep(serverStartDelay)
@@ -133,7 +148,7 @@ func TestHandleDoubleShutdownWhileProcessing(t *testing.T) {
 }
 
 func TestHandlePostGetShutdown(t *testing.T) {
-	s := NewServer(port)
+	s := NewServer(port, model.NewMemoryStore())
 	s.SetDelay(1 * time.Microsecond)
 	go s.Run()
 	time.Sleep(serverStartDelay)
@@ -146,12 +161,28 @@ func TestHandlePostGetShutdown(t *testing.T) {
 	<-s.ShutdownComplete
 }
 
+func TestHandlePostVerifyShutdown(t *testing.T) {
+	s := NewServer(port, model.NewMemoryStore())
+	s.SetDelay(1 * time.Microsecond)
+	go s.Run()
+	time.Sleep(serverStartDelay)
+	postResult := doPost(t)
+	time.Sleep(serverStartDelay)
+	verifyResult := doVerify(t, postResult, "neato mosquito")
+	expected := "{\"match\":true,\"rehashed\":false}"
+	if verifyResult != expected {
+		t.Errorf("Expected %s got %s", expected, verifyResult)
+	}
+	doShutdown()
+	<-s.ShutdownComplete
+}
+
 func TestHandleStatsWithoutHashes(t *testing.T) {
-	s := NewServer(port)
+	s := NewServer(port, model.NewMemoryStore())
 	go s.Run()
 	time.Sleep(serverStartDelay)
 	data := doStats(t)
-	expected := "{\"total\":0,\"average\":0}"
+	expected := "{\"total\":0,\"average\":0,\"verifications\":0,\"expired\":0,\"evicted\":0}"
 	if data != expected {
 		t.Errorf("Expected %s got %s", expected, data)
 	}
@@ -160,7 +191,7 @@ func TestHandleStatsWithoutHashes(t *testing.T) {
 }
 
 func TestHandleStatsAfterShutdown(t *testing.T) {
-	s := NewServer(port)
+	s := NewServer(port, model.NewMemoryStore())
 	go s.Run()
 	time.Sleep(serverStartDelay)
 	doShutdown()